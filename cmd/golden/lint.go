@@ -0,0 +1,23 @@
+package main
+
+import (
+	"fmt"
+	"golden/pkg/lint"
+
+	"github.com/spf13/pflag"
+)
+
+func lintCommand(args []string) {
+	fs := pflag.NewFlagSet("lint", pflag.ExitOnError)
+	sel := addSelectionFlags(fs)
+	fs.Parse(args)
+	sel.mustBeValid(fs)
+
+	defer exitOnPanic(nil)
+
+	rs, inv, r, insts := sel.selectInstances()
+	if problems := lint.Run(rs, inv, r, insts); problems != nil {
+		panic(problems)
+	}
+	fmt.Printf("No problems found in %d instances\n", len(insts))
+}
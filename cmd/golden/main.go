@@ -3,96 +3,181 @@ package main
 import (
 	"fmt"
 	"golden/pkg/deployer"
+	"golden/pkg/fsys"
 	"golden/pkg/git"
 	"golden/pkg/inventory"
 	"golden/pkg/manifest"
 	"golden/pkg/rerrors"
 	"golden/pkg/resolver"
+	"golden/pkg/root"
 	"golden/pkg/rtemplate"
+	"golden/pkg/schema"
+	"golden/pkg/sh"
+	"golden/pkg/varmap"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/spf13/pflag"
 )
 
+const usage = `Usage: golden [command] [flags]
+
+Commands:
+  deploy   deploys instances of a manifest or a group (default)
+  vars     prints resolved variables of instances
+  validate validates variables of instances against schemas of apps
+  lint     checks templates and variables of instances without deploying
+  secrets  encrypts and decrypts secrets in var files
+`
 
 func main() {
-	versionArg := pflag.BoolP("version", "v", false, "displays version of golden")
-	rootDirArg := pflag.StringP("root-dir", "r", ".", "directory with apps, manifests, instances, *_vars and others")
-	manifNameArg := pflag.StringP("manifest", "m", "",
-		"manifest name from manifests.yml or any of manifests/**.yml.\nCannot be specified with \"group\" argument.",
-	)
-	groupNameArg := pflag.StringP("group", "g", "",
-		"group name to deploy.\nDeploys all instances that are part of this group.\nCannot be specified with \"manifest\" argument.",
-	)
-	appsArg := pflag.StringSliceP("apps", "a", []string{},
-		"apps to deploy, comma separated.\nLimits apps to deploy within a specified group or manifest to those listed in this argument.",
-	)
-	locallyArg := pflag.BoolP("locally", "l", false,
-		"ignore ssh* instructions for hosts and deploys all files locally\nto --local-prefix/_install_prefix_ which MUST be specifed.",
-	)
-	installPrefixTemplateArg := pflag.StringP("prefix", "p", "",
-		`Prepends --prefix to install_prefix for instances.
-		E. g. in conjuciton with --locally deploys all files locally to this --prefix.
-		Can be a template with builtin variables available.`,
-	)
+	command := "deploy"
+	args := os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command = args[0]
+		args = args[1:]
+	}
 
-	pflag.Parse()
+	switch command {
+	case "deploy":
+		deployCommand(args)
+	case "vars":
+		varsCommand(args)
+	case "validate":
+		validateCommand(args)
+	case "lint":
+		lintCommand(args)
+	case "secrets":
+		secretsCommand(args)
+	case "help":
+		fmt.Fprint(os.Stderr, usage)
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command: %s\n%s", command, usage)
+		os.Exit(1)
+	}
+}
 
-	if *versionArg {
-		fmt.Printf("golden version: %s\n", git.Version)
-		os.Exit(0)
+type selectionArgs struct {
+	rootDirs              *[]string
+	manifName             *string
+	groupName             *string
+	apps                  *[]string
+	limit                 *string
+	locally               *bool
+	installPrefixTemplate *string
+	secretsKeyFile        *string
+	vars                  *[]string
+	varsFiles             *[]string
+	env                   *string
+}
+
+func addSelectionFlags(fs *pflag.FlagSet) *selectionArgs {
+	return &selectionArgs{
+		rootDirs: fs.StringArrayP("root-dir", "r", []string{"."},
+			"directory with apps, manifests, instances, *_vars and others.\nCan be repeated, later directories override earlier ones.\nDirectories listed in \"includes\" of <root-dir>/golden.yml are added before it.",
+		),
+		manifName: fs.StringP("manifest", "m", "",
+			"manifest name from manifests.yml or any of manifests/**.yml.\n.yaml and .json files are read as well.\nCannot be specified with \"group\" argument.",
+		),
+		groupName: fs.StringP("group", "g", "",
+			"group name to deploy.\nDeploys all instances that are part of this group.\nAccepts any selector expression as well, see --limit.\nCannot be specified with \"manifest\" argument.",
+		),
+		apps: fs.StringSliceP("apps", "a", []string{},
+			"apps to deploy, comma separated.\nLimits apps to deploy within a specified group or manifest to those listed in this argument.",
+		),
+		limit: fs.String("limit", "",
+			"selector expression further limiting instances of a manifest or a group,\ne. g. \"web:&prod:!canary\" or \"group(prod) & app(nginx) - host(db01)\".",
+		),
+		locally: fs.BoolP("locally", "l", false,
+			"ignore ssh* instructions for hosts and deploys all files locally\nto --local-prefix/_install_prefix_ which MUST be specifed.",
+		),
+		installPrefixTemplate: fs.StringP("prefix", "p", "",
+			`Prepends --prefix to install_prefix for instances.
+		E. g. in conjuciton with --locally deploys all files locally to this --prefix.
+		Can be a template with builtin variables available.`,
+		),
+		secretsKeyFile: addSecretsKeyFileFlag(fs),
+		env: fs.StringP("env", "E", "",
+			"environment from envs/<env> overriding hosts, instances and variables\nof the root directory, available as ._env_.",
+		),
+		vars: fs.StringArray("var", []string{},
+			"sets a variable overriding all others except builtins, e. g. --var release.version=1.2.\nValues are strings. Can be repeated.",
+		),
+		varsFiles: fs.StringArray("vars-file", []string{},
+			"yaml file with variables overriding all others except builtins and --var.\nCan be repeated, later files override earlier ones.",
+		),
 	}
+}
 
-	if *groupNameArg == "" && *manifNameArg == "" {
+func (a *selectionArgs) mustBeValid(fs *pflag.FlagSet) {
+	if *a.groupName == "" && *a.manifName == "" {
 		fmt.Fprintln(os.Stderr, "Either --manifest or --group must be specified")
-		pflag.Usage()
+		fs.Usage()
 		os.Exit(1)
 	}
+}
 
-	var rep *deployer.Report
-	var timeSpentOnResolving time.Duration
+// load reads the inventory of all roots and resolves variables of all selected instances.
+// Errors of all instances are reported at once.
+func (a *selectionArgs) load() (root.Roots, *inventory.Inventory, *resolver.Resolver, []*inventory.Instance) {
+	rs, inv, r, selected := a.selectInstances()
+	errs := &rerrors.Collector{}
+	for _, inst := range selected {
+		errs.Catch(func() { r.ResolveInstance(inst) })
+	}
+	errs.PanicIfAny()
+	for _, inst := range selected {
+		errs.Catch(func() { inv.OverrideHost(inst.Host, r.ResolveHost(inst)) })
+	}
+	errs.PanicIfAny()
+	return rs, inv, r, selected
+}
 
-	defer func() {
-		recovered := recover()
-		ok := true
-		rerrors.Recover(recovered, &ok)
-		if rep != nil {
-			fmt.Fprintf(os.Stderr, "Spent on resolving variables: %s\n", timeSpentOnResolving.String())
-			fmt.Fprint(os.Stderr, rep.String())
+// selectInstances reads the inventory of all roots and selects instances
+// without resolving their variables.
+func (a *selectionArgs) selectInstances() (root.Roots, *inventory.Inventory, *resolver.Resolver, []*inventory.Instance) {
+	setSecretsKeyFile(*a.secretsKeyFile)
+	errs := &rerrors.Collector{}
+	cliVars := varmap.New()
+	for _, file := range *a.varsFiles {
+		if !fsys.DoesFileExists(file) {
+			errs.Add(rerrors.NewErrStringf("--vars-file %s does not exist", file))
+			continue
 		}
-		if ok {
-			os.Exit(0)
-		} else {
-			os.Exit(1)
-		}
-	}()
-
-	err := os.Chdir(*rootDirArg)
-	if err != nil {
-		panic(err)
+		errs.Catch(func() { cliVars = varmap.Merge(cliVars, varmap.ReadCliFile(file), varmap.ConflictResolutionOverride) })
 	}
+	errs.Catch(func() {
+		cliVars = varmap.Merge(cliVars, varmap.ParseAssignments(*a.vars), varmap.ConflictResolutionOverride)
+	})
 
-	resolvingStarted := time.Now()
-	inv := inventory.ReadInventory("")
-	r := resolver.New("", inv)
+	rs := root.Read(*a.rootDirs)
+	var inv *inventory.Inventory
+	var manifests manifest.ManifestsCollection
+	errs.Catch(func() { inv = inventory.ReadInventory(rs, *a.env) })
+	errs.Catch(func() { manifests = manifest.ReadManifestsCollection(rs.Paths("manifests")...) })
+	errs.PanicIfAny()
+
+	r := resolver.New(rs, inv)
+	r.SetEnv(*a.env)
+	r.SetCliVars(cliVars)
 
-	if *locallyArg {
+	if *a.locally {
 		inv.SetHostsToLocalhost()
 	}
-	if *installPrefixTemplateArg != "" {
+	if *a.installPrefixTemplate != "" {
 		insts := inv.GetAllInstances()
 		overrides := map[string]string{}
-		tmpl, err := rtemplate.New("--prefix").Parse(*installPrefixTemplateArg)
+		tmpl, err := rtemplate.New("--prefix").Parse(*a.installPrefixTemplate)
 		if err != nil {
 			panic(rtemplate.NewErrParse("--prefix", err))
 		}
 		for k, v := range insts {
-			vars := resolver.CreateBuiltInVars(v)
+			vars := resolver.CreateBuiltInVars(v, *a.env)
 			preparedVars, substError := vars.SubstituteTemplatedVars()
 			if substError != nil {
-				panic(err)
+				panic(substError)
 			}
 			prefix, err := rtemplate.ExecToString(tmpl, preparedVars)
 			if err != nil {
@@ -103,36 +188,104 @@ func main() {
 		inv.OverrideInstallPrefix(overrides)
 	}
 
-	manifests := manifest.ReadManifestsCollection("manifests")
-
 	var manif *manifest.Manifest
 	var ok bool
-	if *manifNameArg != "" {
-		manif, ok = manifests[*manifNameArg]
+	if *a.manifName != "" {
+		manif, ok = manifests[*a.manifName]
 		if !ok {
-			panic(rerrors.NewErrStringf("--manifest %s does not exist", *manifNameArg))
+			panic(rerrors.NewErrStringf("--manifest %s does not exist", *a.manifName))
 		}
 	} else {
-		manif = &manifest.Manifest{*groupNameArg}
+		manif = &manifest.Manifest{Selectors: []string{*a.groupName}}
 	}
 
 	appsWhiteList := map[string]struct{}{}
-	for _, app := range *appsArg {
+	for _, app := range *a.apps {
 		appsWhiteList[app] = struct{}{}
 	}
 
-	for _, inst := range inv.GetInstancesForManifest(*manif) {
+	limitedTo := map[string]struct{}{}
+	if *a.limit != "" {
+		for _, inst := range inv.Select(*a.limit) {
+			limitedTo[inst.Name] = struct{}{}
+		}
+	}
+
+	selected := []*inventory.Instance{}
+	for _, inst := range inv.GetInstancesForManifest(manif) {
+		if *a.limit != "" {
+			if _, ok := limitedTo[inst.Name]; !ok {
+				continue
+			}
+		}
 		if len(appsWhiteList) > 0 {
 			if _, ok := appsWhiteList[inst.App]; !ok {
 				continue
 			}
 		}
 
-		r.ResolveInstance(inst)
+		selected = append(selected, inst)
+	}
+
+	return rs, inv, r, selected
+}
+
+// exitOnPanic reports a recovered panic and exits. Must be deferred.
+func exitOnPanic(beforeExit func()) {
+	recovered := recover()
+	ok := true
+	rerrors.Recover(recovered, &ok)
+	if beforeExit != nil {
+		beforeExit()
 	}
+	if ok {
+		os.Exit(0)
+	} else {
+		os.Exit(1)
+	}
+}
+
+func deployCommand(args []string) {
+	fs := pflag.NewFlagSet("deploy", pflag.ExitOnError)
+	versionArg := fs.BoolP("version", "v", false, "displays version of golden")
+	askBecomePassArg := fs.BoolP("ask-become-pass", "K", false,
+		"prompt once for a sudo password used for become_user on all hosts",
+	)
+	sel := addSelectionFlags(fs)
+	fs.Parse(args)
+
+	if *versionArg {
+		fmt.Printf("golden version: %s\n", git.Version)
+		os.Exit(0)
+	}
+
+	sel.mustBeValid(fs)
+
+	becomePassword := ""
+	if *askBecomePassArg {
+		becomePassword = sh.MustReadPassword("BECOME password: ")
+	}
+
+	var rep *deployer.Report
+	var timeSpentOnResolving time.Duration
+
+	defer exitOnPanic(func() {
+		if rep != nil {
+			fmt.Fprintf(os.Stderr, "Spent on resolving variables: %s\n", timeSpentOnResolving.String())
+			fmt.Fprint(os.Stderr, rep.String())
+		}
+	})
+
+	resolvingStarted := time.Now()
+	rs, inv, r, insts := sel.load()
 
 	timeSpentOnResolving = time.Since(resolvingStarted)
+	if problems := validateInstances(rs, r, insts); len(problems) != 0 {
+		panic(&schema.ErrInvalidVars{Problems: problems})
+	}
 	resolvedVars, substitutionErrors := r.GetAllResolvedVarsAndErrors()
-	d := deployer.New(resolvedVars, substitutionErrors, inv)
-	rep = d.Deploy(*manif, *appsArg)
+	d := deployer.New(rs, resolvedVars, substitutionErrors, inv)
+	d.SetBecomePassword(becomePassword)
+	d.SetTemplateFuncs(r.Funcs())
+	rep = d.Deploy(insts)
 }
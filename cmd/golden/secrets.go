@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"golden/pkg/rerrors"
+	"golden/pkg/secrets"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/spf13/pflag"
+)
+
+const secretsUsage = `Usage: golden secrets <command> [flags] [files]
+
+Commands:
+  encrypt FILE...           encrypts whole files in place
+  encrypt --string VALUE    prints VALUE encrypted to be used as "key: !encrypted ..."
+  decrypt FILE...           decrypts whole files in place
+  decrypt --string VALUE    prints a decrypted value
+  edit FILE                 decrypts a file into $EDITOR and encrypts it back
+  rekey FILE...             re-encrypts whole files and !encrypted values
+                            with a new passphrase
+
+The passphrase is read from --secrets-key-file, $GOLDEN_SECRETS_KEY_FILE,
+$GOLDEN_SECRETS_PASSPHRASE or ~/.golden/secrets.key, otherwise it is asked.
+`
+
+func addSecretsKeyFileFlag(fs *pflag.FlagSet) *string {
+	return fs.String("secrets-key-file", "",
+		"file with a passphrase of encrypted secrets.\nDefaults to $GOLDEN_SECRETS_KEY_FILE.",
+	)
+}
+
+func setSecretsKeyFile(filename string) {
+	if filename != "" {
+		secrets.SetKeyFile(filename)
+	}
+}
+
+func secretsCommand(args []string) {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, secretsUsage)
+		os.Exit(1)
+	}
+	command, args := args[0], args[1:]
+
+	fs := pflag.NewFlagSet("secrets "+command, pflag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, secretsUsage)
+		fs.PrintDefaults()
+	}
+	keyFile := addSecretsKeyFileFlag(fs)
+	var stringArg, newKeyFileArg *string
+	switch command {
+	case "encrypt", "decrypt":
+		stringArg = fs.StringP("string", "s", "", "a single value to encrypt or decrypt instead of files")
+	case "rekey":
+		newKeyFileArg = fs.String("new-key-file", "", "file with the new passphrase, asked if not specified")
+	case "edit":
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown secrets command: %s\n%s", command, secretsUsage)
+		os.Exit(1)
+	}
+	fs.Parse(args)
+
+	defer exitOnPanic(nil)
+
+	setSecretsKeyFile(*keyFile)
+	files := fs.Args()
+	if (stringArg == nil || *stringArg == "") && len(files) == 0 {
+		panic(rerrors.NewErrStringf("no files specified"))
+	}
+
+	switch command {
+	case "encrypt":
+		passphrase := secrets.MustNewPassphrase(*keyFile)
+		if *stringArg != "" {
+			fmt.Printf("%s %s\n", secrets.Tag, secrets.Encrypt([]byte(*stringArg), passphrase))
+			return
+		}
+		for _, file := range files {
+			data := mustReadSecretsFile(file)
+			if secrets.IsEncrypted(data) {
+				panic(rerrors.NewErrStringf("%s is already encrypted", file))
+			}
+			mustWriteSecretsFile(file, []byte(secrets.Encrypt(data, passphrase)+"\n"))
+		}
+	case "decrypt":
+		if *stringArg != "" {
+			fmt.Println(secrets.MustDecrypt(*stringArg, "--string"))
+			return
+		}
+		for _, file := range files {
+			data := mustReadSecretsFile(file)
+			if !secrets.IsEncrypted(data) {
+				panic(rerrors.NewErrStringf("%s is not encrypted", file))
+			}
+			mustWriteSecretsFile(file, []byte(secrets.MustDecrypt(string(data), file)))
+		}
+	case "edit":
+		if len(files) != 1 {
+			panic(rerrors.NewErrStringf("edit accepts a single file"))
+		}
+		editSecretsFile(files[0])
+	case "rekey":
+		oldPassphrase := secrets.MustPassphrase()
+		var newPassphrase string
+		if *newKeyFileArg != "" {
+			newPassphrase = secrets.MustNewPassphrase(*newKeyFileArg)
+		} else {
+			newPassphrase = secrets.MustAskNewPassphrase()
+		}
+		if newPassphrase == oldPassphrase {
+			panic(rerrors.NewErrStringf("the new passphrase is the same as the old one"))
+		}
+		for _, file := range files {
+			data := mustReadSecretsFile(file)
+			rekeyed := secrets.ValueRegexp.ReplaceAllFunc(data, func(value []byte) []byte {
+				return []byte(secrets.Encrypt([]byte(secrets.MustDecrypt(string(value), file)), newPassphrase))
+			})
+			mustWriteSecretsFile(file, rekeyed)
+		}
+	}
+}
+
+func editSecretsFile(file string) {
+	plaintext := []byte{}
+	var passphrase string
+	if _, err := os.Stat(file); err == nil {
+		data := mustReadSecretsFile(file)
+		if !secrets.IsEncrypted(data) {
+			panic(rerrors.NewErrStringf("%s is not encrypted, encrypt it first", file))
+		}
+		plaintext = []byte(secrets.MustDecrypt(string(data), file))
+		passphrase = secrets.MustPassphrase()
+	} else {
+		passphrase = secrets.MustNewPassphrase("")
+	}
+
+	tmp, err := os.CreateTemp("", "golden-secrets-*"+filepath.Ext(file))
+	if err != nil {
+		panic(err)
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(plaintext)
+	tmp.Close()
+	if err != nil {
+		panic(rerrors.NewErrIo(tmp.Name(), "writing a decrypted file", err))
+	}
+
+	editor := os.Getenv("EDITOR")
+	if editor == "" {
+		editor = "vi"
+	}
+	cmd := exec.Command("sh", "-c", editor+` "$1"`, "sh", tmp.Name())
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		panic(err)
+	}
+
+	edited, err := os.ReadFile(tmp.Name())
+	if err != nil {
+		panic(rerrors.NewErrIo(tmp.Name(), "reading an edited file", err))
+	}
+	if bytes.Equal(edited, plaintext) {
+		fmt.Fprintf(os.Stderr, "%s is not changed\n", file)
+		return
+	}
+	mustWriteSecretsFile(file, []byte(secrets.Encrypt(edited, passphrase)+"\n"))
+}
+
+func mustReadSecretsFile(file string) []byte {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		panic(rerrors.NewErrIo(file, "reading secrets", err))
+	}
+	return data
+}
+
+// mustWriteSecretsFile keeps permissions of an existing file.
+func mustWriteSecretsFile(file string, data []byte) {
+	perm := os.FileMode(0600)
+	if fi, err := os.Stat(file); err == nil {
+		perm = fi.Mode().Perm()
+	}
+	if err := os.WriteFile(file, data, perm); err != nil {
+		panic(rerrors.NewErrIo(file, "writing secrets", err))
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"golden/pkg/inventory"
+	"golden/pkg/resolver"
+	"golden/pkg/root"
+	"golden/pkg/schema"
+	"golden/pkg/varmap"
+	"sort"
+
+	"github.com/spf13/pflag"
+)
+
+func validateCommand(args []string) {
+	fs := pflag.NewFlagSet("validate", pflag.ExitOnError)
+	sel := addSelectionFlags(fs)
+	fs.Parse(args)
+	sel.mustBeValid(fs)
+
+	defer exitOnPanic(nil)
+
+	rs, _, r, insts := sel.load()
+	problems := validateInstances(rs, r, insts)
+	for _, inst := range insts {
+		_, substErr := r.ResolveInstance(inst)
+		if substErr == nil {
+			continue
+		}
+		unresolved := make([]*varmap.Var, 0, len(substErr.Vars))
+		for v := range substErr.Vars {
+			unresolved = append(unresolved, v)
+		}
+		sort.Slice(unresolved, func(i, j int) bool {
+			a, b := unresolved[i], unresolved[j]
+			if a.Source != b.Source {
+				return a.Source < b.Source
+			}
+			if a.Line != b.Line {
+				return a.Line < b.Line
+			}
+			return a.Col < b.Col
+		})
+		for _, v := range unresolved {
+			problems[inst.Name] = append(problems[inst.Name],
+				fmt.Sprintf("%s: can not be resolved, defined in %s", v.Path, v.Location()))
+		}
+	}
+	if len(problems) != 0 {
+		panic(&schema.ErrInvalidVars{Problems: problems})
+	}
+	fmt.Printf("Variables of %d instances are valid\n", len(insts))
+}
+
+// validateInstances checks resolved variables of instances against
+// schemas of their apps and returns problems by instance names.
+func validateInstances(rs root.Roots, r *resolver.Resolver, insts []*inventory.Instance) map[string][]string {
+	schemas := map[string]schema.Schema{}
+	problems := map[string][]string{}
+	for _, inst := range insts {
+		s, ok := schemas[inst.App]
+		if !ok {
+			s = schema.ForApp(rs, inst.App)
+			schemas[inst.App] = s
+		}
+		if len(s) == 0 {
+			continue
+		}
+		vars, _ := r.ResolveInstance(inst)
+		if p := s.Validate(vars, r.SourceOf(inst), r.IsSecret(inst)); len(p) != 0 {
+			problems[inst.Name] = p
+		}
+	}
+	return problems
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"golden/pkg/rerrors"
+	"golden/pkg/resolver"
+	"golden/pkg/varmap"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/spf13/pflag"
+)
+
+func varsCommand(args []string) {
+	fs := pflag.NewFlagSet("vars", pflag.ExitOnError)
+	sel := addSelectionFlags(fs)
+	explainArg := fs.BoolP("explain", "e", false,
+		"for every variable list all layers defining it,\nfrom the lowest precedence to the highest one that wins.",
+	)
+	fs.Parse(args)
+	sel.mustBeValid(fs)
+
+	defer exitOnPanic(nil)
+
+	_, _, r, insts := sel.load()
+
+	for _, inst := range insts {
+		fmt.Printf("==> %s <==\n", inst.Name)
+		if *explainArg {
+			printExplanations(os.Stdout, r.ExplainInstance(inst))
+			continue
+		}
+		vars, substErr := r.ResolveInstance(inst)
+		printResolvedVars("", vars, r.IsSecret(inst))
+		if substErr != nil {
+			fmt.Fprintln(os.Stderr, rerrors.Redact(substErr.NiceError()))
+		}
+	}
+}
+
+func printResolvedVars(prefix string, vars map[string]interface{}, isSecret func(path string) bool) {
+	keys := make([]string, 0, len(vars))
+	for k := range vars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if sub, ok := vars[k].(map[string]interface{}); ok {
+			printResolvedVars(prefix+k+".", sub, isSecret)
+			continue
+		}
+		if isSecret(prefix + k) {
+			fmt.Printf("%s%s = %s\n", prefix, k, rerrors.Redacted)
+			continue
+		}
+		fmt.Printf("%s%s = %s\n", prefix, k, formatValue(vars[k]))
+	}
+}
+
+func printExplanations(w io.Writer, explanations []*resolver.Explanation) {
+	for _, e := range explanations {
+		fmt.Fprintf(w, "%s = %s\n", e.Path, formatVar(e.Winner().Var))
+		for i := len(e.Definitions) - 1; i >= 0; i-- {
+			def := e.Definitions[i]
+			mark := "  "
+			if def == e.Winner() {
+				mark = "=>"
+			}
+			fmt.Fprintf(
+				w, "    %s %s: %s [%s]\n",
+				mark, def.Layer, formatVar(def.Var), def.Var.Location(),
+			)
+		}
+	}
+}
+
+func formatVar(v *varmap.Var) string {
+	if v.Secret {
+		return rerrors.Redacted
+	}
+	if _, ok := v.Value.(varmap.VarMap); ok {
+		return "a map"
+	}
+	return formatValue(v.Value)
+}
+
+// formatValue redacts secrets, including values templated from them.
+func formatValue(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return fmt.Sprintf("%q", rerrors.Redact(s))
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return rerrors.Redact(strings.TrimSpace(fmt.Sprintf("%v", v)))
+	}
+	return rerrors.Redact(string(b))
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"golden/pkg/inventory"
+	"golden/pkg/resolver"
+	"golden/pkg/root"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExplainGroupPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	for file, content := range map[string]string{
+		"hosts.yml":     "h1: {}\n",
+		"instances.yml": "i1: {app: app, host: h1}\n",
+		// priority wins over depth and names, depth wins over names
+		"groups.yml": "a-high: {priority: 1, members: [i1]}\n" +
+			"z-low: [i1]\n" +
+			"parent: [child]\n" +
+			"child: [i1]\n" +
+			"aa: [i1]\n" +
+			"zz: [i1]\n",
+		"group_vars/a-high.yml": "by_priority: a-high\n",
+		"group_vars/z-low.yml":  "by_priority: z-low\nby_name: z-low\n",
+		"group_vars/parent.yml": "by_depth: parent\n",
+		"group_vars/child.yml":  "by_depth: child\n",
+		"group_vars/aa.yml":     "by_name: aa\nmap: x\n",
+		"group_vars/zz.yml":     "map: {k: v}\n",
+	} {
+		path := filepath.Join(dir, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rs := root.Roots{dir}
+	inv := inventory.ReadInventory(rs, "")
+	r := resolver.New(rs, inv)
+	inst := inv.GetAllInstances()["i1"]
+
+	vars, err := r.ResolveInstance(inst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]interface{}{
+		"by_priority": "a-high",
+		"by_depth":    "child",
+		"by_name":     "z-low",
+	} {
+		if vars[path] != want {
+			t.Errorf("%s = %v, want %v", path, vars[path], want)
+		}
+	}
+
+	out := &strings.Builder{}
+	explanations := []*resolver.Explanation{}
+	for _, e := range r.ExplainInstance(inst) {
+		if !strings.HasPrefix(e.Path, "_") {
+			explanations = append(explanations, e)
+		}
+	}
+	printExplanations(out, explanations)
+	gv := filepath.Join(dir, "group_vars")
+	want := `by_depth = "child"
+    => group child (priority 0, depth 1): "child" [` + gv + `/child.yml:1:1]
+       group parent (priority 0, depth 0): "parent" [` + gv + `/parent.yml:1:1]
+by_name = "z-low"
+    => group z-low (priority 0, depth 0): "z-low" [` + gv + `/z-low.yml:2:1]
+       group aa (priority 0, depth 0): "aa" [` + gv + `/aa.yml:1:1]
+by_priority = "a-high"
+    => group a-high (priority 1, depth 0): "a-high" [` + gv + `/a-high.yml:1:1]
+       group z-low (priority 0, depth 0): "z-low" [` + gv + `/z-low.yml:1:1]
+map = a map
+    => group zz (priority 0, depth 0): a map [` + gv + `/zz.yml:1:1]
+       group aa (priority 0, depth 0): "x" [` + gv + `/aa.yml:2:1]
+map.k = "v"
+    => group zz (priority 0, depth 0): "v" [` + gv + `/zz.yml:1:7]
+`
+	if out.String() != want {
+		t.Errorf("got explanations\n%s\nwant\n%s", out, want)
+	}
+}
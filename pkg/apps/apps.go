@@ -0,0 +1,85 @@
+package apps
+
+import (
+	"golden/pkg/rerrors"
+	"golden/pkg/root"
+	"golden/pkg/ryaml"
+	"path/filepath"
+	"strings"
+)
+
+// Dir holds apps within a root. An app is taken from the root
+// of the highest precedence having it.
+const Dir = "apps"
+
+// MetaDir holds golden's own files of an app. It is never deployed.
+const MetaDir = ".golden"
+
+// ConfigFile within MetaDir composes an app of other apps:
+//
+//	extends: base-app
+//	includes: [fragment-a, fragment-b]
+//
+// Files and app_vars of the app override those of included apps,
+// which override those of the extended app.
+const ConfigFile = "app.yml"
+
+type config struct {
+	Extends  string   `yaml:"extends"`
+	Includes []string `yaml:"includes"`
+}
+
+// Layer is an app an instance's app is composed of.
+type Layer struct {
+	Name string
+	Dir  string
+}
+
+// Exists reports whether an app has a directory in any of the roots.
+func Exists(rs root.Roots, app string) bool {
+	return rs.Find(filepath.Join(Dir, app)) != ""
+}
+
+// FindDir returns the directory of an app.
+func FindDir(rs root.Roots, app string) string {
+	dir := rs.Find(filepath.Join(Dir, app))
+	if dir == "" {
+		panic(rerrors.NewErrStringf("app %s does not exist in %s", app, strings.Join(rs, ", ")))
+	}
+	return dir
+}
+
+// Layers returns apps an app is composed of from the lowest precedence
+// to the highest, the app itself being the last one. An app appearing
+// several times is kept at its first occurrence.
+func Layers(rs root.Roots, app string) []*Layer {
+	layers := []*Layer{}
+	seen := map[string]struct{}{}
+	var add func(app string, chain []string)
+	add = func(app string, chain []string) {
+		chain = append(chain, app)
+		for _, c := range chain[:len(chain)-1] {
+			if c == app {
+				panic(rerrors.NewErrStringf("cyclic composition of apps: %s", strings.Join(chain, " -> ")))
+			}
+		}
+		if _, ok := seen[app]; ok {
+			return
+		}
+		dir := FindDir(rs, app)
+		cfg := config{}
+		if configFile := ryaml.FindFile(filepath.Join(dir, MetaDir, ConfigFile)); configFile != "" {
+			ryaml.ReadYamlFile(configFile, &cfg)
+		}
+		if cfg.Extends != "" {
+			add(cfg.Extends, chain)
+		}
+		for _, include := range cfg.Includes {
+			add(include, chain)
+		}
+		seen[app] = struct{}{}
+		layers = append(layers, &Layer{Name: app, Dir: dir})
+	}
+	add(app, nil)
+	return layers
+}
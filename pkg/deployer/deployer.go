@@ -1,13 +1,15 @@
 package deployer
 
 import (
+	"bytes"
 	"crypto/rand"
 	_ "embed"
 	"fmt"
+	"golden/pkg/apps"
 	"golden/pkg/fsys"
 	"golden/pkg/inventory"
-	"golden/pkg/manifest"
 	"golden/pkg/rerrors"
+	"golden/pkg/root"
 	"golden/pkg/rtemplate"
 	"golden/pkg/sh"
 	"golden/pkg/varmap"
@@ -22,50 +24,63 @@ import (
 )
 
 type Deployer struct {
+	roots                root.Roots
 	resolvedInstanceVars map[string]map[string]interface{}
 	substitutionErrors map[string]*varmap.ErrUnresolvedVariables
 	inv                  *inventory.Inventory
 	report               *Report
 	hosts                []string
 	hostToInstances      map[string][]*inventory.Instance
-	parsedTemplates      map[string]*template.Template
+	parsedTemplates      map[string]map[string]*template.Template
+	parsedPartials       map[string]*template.Template
+	layers               map[string][]*apps.Layer
+	fileRules            map[string]FileRules
+	ownerships           map[string]ownership
 	sshControlPath       string
 	localTmpDir          string
 	remoteTmpDir         string
+	becomePassword       string
+	funcs                template.FuncMap
 }
 
 func New(
+	rs root.Roots,
 	resolvedInstanceVars map[string]map[string]interface{},
 	varSubstitionErrors map[string]*varmap.ErrUnresolvedVariables,
 	inv *inventory.Inventory,
 ) *Deployer {
 	return &Deployer{
+		roots:                rs,
 		resolvedInstanceVars: resolvedInstanceVars,
 		substitutionErrors: varSubstitionErrors,
 		inv:                  inv,
 		report:               NewReport(),
 		hosts:                []string{},
 		hostToInstances:      map[string][]*inventory.Instance{},
-		parsedTemplates:      map[string]*template.Template{},
+		parsedTemplates:      map[string]map[string]*template.Template{},
+		parsedPartials:       map[string]*template.Template{},
+		layers:               map[string][]*apps.Layer{},
+		fileRules:            map[string]FileRules{},
+		ownerships:           map[string]ownership{},
 		sshControlPath:       "",
 		localTmpDir:          "",
 		remoteTmpDir:         "",
 	}
 }
 
-func (d *Deployer) Deploy(manif manifest.Manifest, appsWhitelist []string) *Report {
-	appsWhiteMap := map[string]struct{}{}
-	for _, app := range appsWhitelist {
-		appsWhiteMap[app] = struct{}{}
-	}
+// SetBecomePassword sets a password passed to sudo when becoming another user.
+func (d *Deployer) SetBecomePassword(password string) {
+	d.becomePassword = password
+}
 
+// SetTemplateFuncs adds functions to file templates, e. g. resolver.Funcs.
+func (d *Deployer) SetTemplateFuncs(funcs template.FuncMap) {
+	d.funcs = funcs
+}
+
+func (d *Deployer) Deploy(insts []*inventory.Instance) *Report {
 	d.constructTmpDirNames()
-	for _, inst := range d.inv.GetInstancesForManifest(manif) {
-		if len(appsWhiteMap) > 0 {
-			if _, ok := appsWhiteMap[inst.App]; !ok {
-				continue
-			}
-		}
+	for _, inst := range insts {
 		host := inst.Host
 		if list, ok := d.hostToInstances[host]; ok {
 			d.hostToInstances[host] = append(list, inst)
@@ -106,7 +121,7 @@ func (d *Deployer) constructTmpDirNames() {
 	if err != nil {
 		panic(err)
 	}
-	d.localTmpDir = fmt.Sprintf(".golden-local-%s-%d-%x", date, pid, random)
+	d.localTmpDir = filepath.Join(os.TempDir(), fmt.Sprintf(".golden-local-%s-%d-%x", date, pid, random))
 	d.remoteTmpDir = fmt.Sprintf(".golden-remote-%s-%d-%x", date, pid, random)
 	d.sshControlPath = filepath.Join(d.localTmpDir, ".golden-ssh-control-path")
 }
@@ -127,7 +142,7 @@ func (d *Deployer) deployToHost(host string) {
 
 	r.HostPackingStarted()
 	packedHostPath := filepath.Join(d.localTmpDir, host) + ".tar.gz"
-	sh.MustDoSilentlyf("tar -C %s -cvzpf %s %s", d.localTmpDir, packedHostPath, host)
+	packTarGz(d.localTmpDir, host, packedHostPath)
 	r.HostPackingDone()
 
 	if err := os.RemoveAll(filepath.Join(d.localTmpDir, host)); err != nil {
@@ -148,7 +163,7 @@ func (d *Deployer) deployToHost(host string) {
 	var executor sh.Executor
 	hostRemoteTmpDir := d.remoteTmpDir
 	if !hostData.IsLocalHost() {
-		ssh := sh.NewSshSession(d.sshControlPath, hostData.GetSshConnStr())
+		ssh := sh.NewSshSession(d.sshControlPath, hostData.GetSshConnStr(), hostData.GetSshArgs())
 		defer ssh.Close()
 		executor = ssh
 	} else if !hostData.IsThisUser() {
@@ -174,39 +189,71 @@ func (d *Deployer) deployToHost(host string) {
 	executor.MustDoSilentlyf("rm -rf %s.tar.gz", filepath.Join(hostRemoteTmpDir, host))
 	for _, inst := range d.hostToInstances[host] {
 		r.InstanceDeployStarted()
+		instExecutor := executor
+		instPrefixRoot := installPrefixRoot
+		asUser := ""
+		if becomeUser, becomeMethod := inst.GetBecome(hostData); becomeUser != "" {
+			// relative install prefixes are relative to the home of the user to become
+			instExecutor = sh.NewBecome(executor, becomeUser, becomeMethod, d.becomePassword)
+			instPrefixRoot = ""
+			asUser = " as " + becomeUser
+		}
 		var deployPath string
 		if filepath.IsAbs(inst.InstallPrefix) || strings.HasPrefix(inst.InstallPrefix, "~") {
 			deployPath = inst.InstallPrefix
 		} else {
-			deployPath = filepath.Join(installPrefixRoot, inst.InstallPrefix)
+			deployPath = filepath.Join(instPrefixRoot, inst.InstallPrefix)
 		}
 		if strings.TrimSpace(deployPath) == "" {
 			deployPath = "."
 		}
-		fmt.Fprintf(os.Stderr, "%s unpacking %s to %s\n", hostData, inst.Name, deployPath)
-		executor.MustDoSilentlyf("mkdir -p %s", deployPath)
-		executor.MustDoSilentlyf(
-			"tar --no-same-owner -C %s -xvpf %s.tar",
+		fmt.Fprintf(os.Stderr, "%s unpacking %s to %s%s\n", hostData, inst.Name, deployPath, asUser)
+		instExecutor.MustDoSilentlyf("mkdir -p %s", deployPath)
+		// the archive is read by the login user, so the user to become does not need access to it
+		instExecutor.MustDoSilentlyFromFilef(
+			filepath.Join(hostRemoteTmpDir, host, inst.Name)+".tar",
+			"tar --no-same-owner -m -C %s -xvpf -",
 			deployPath,
-			filepath.Join(hostRemoteTmpDir, host, inst.Name),
 		)
+		for _, chown := range d.ownerships[inst.Name].commands() {
+			instExecutor.MustDoSilentlyf("cd %s && %s", deployPath, chown)
+		}
 		r.InstanceDeployDone(true)
 	}
 }
 
-func (d *Deployer) parseTemplate(filename string) *template.Template {
-	if t, ok := d.parsedTemplates[filename]; ok {
+func (d *Deployer) appLayers(app string) []*apps.Layer {
+	if layers, ok := d.layers[app]; ok {
+		return layers
+	}
+	layers := apps.Layers(d.roots, app)
+	d.layers[app] = layers
+	return layers
+}
+
+// parseTemplate parses a template file with partials of an app. Templates
+// are cached by app, as apps extending the same app share its files but
+// may have different partials.
+func (d *Deployer) parseTemplate(app, filename string) *template.Template {
+	if _, ok := d.parsedTemplates[app]; !ok {
+		d.parsedTemplates[app] = map[string]*template.Template{}
+	}
+	if t, ok := d.parsedTemplates[app][filename]; ok {
 		return t
 	}
 	fileContents, err := os.ReadFile(filename)
 	if err != nil {
 		panic(rerrors.NewErrIo(filename, "parseTemplate", err))
 	}
-	t, err := rtemplate.New(filename).Option("missingkey=error").Parse(string(fileContents))
+	t, err := d.partials(app).Clone()
+	if err != nil {
+		panic(err)
+	}
+	t, err = t.New(filename).Parse(string(fileContents))
 	if err != nil {
 		panic(rtemplate.NewErrParse(filename, err))
 	}
-	d.parsedTemplates[filename] = t
+	d.parsedTemplates[app][filename] = t
 	return t
 }
 
@@ -216,46 +263,49 @@ func (d *Deployer) packInstance(inst *inventory.Instance, r *SingleReport) {
 	defer r.InstancePackingDone()
 
 	app := inst.App
-	appFiles, err := fsys.GetAllFilesRecursive(filepath.Join("apps", app))
-	if err != nil {
-		panic(err)
-	}
+	instVars := d.resolvedInstanceVars[inst.Name]
 	instDir := filepath.Join(d.localTmpDir, inst.Host, inst.Name)
-	err = os.Mkdir(instDir, 0755)
+	err := os.Mkdir(instDir, 0755)
 	if err != nil {
 		panic(err)
 	}
-	for _, file := range appFiles {
-		dstFile := strings.Replace(file, filepath.Join("apps", inst.App), instDir, 1)
-
-		isTemplate := strings.HasSuffix(file, ".gotmpl")
-		if isTemplate {
-			dstFile = dstFile[0 : len(dstFile)-len(".gotmpl")]
-		} else {
-			isIgnoredTemplate := strings.HasSuffix(file, ".gotmpl_")
-			if isIgnoredTemplate {
-				dstFile = dstFile[0 : len(dstFile)-len("_")]
-			}
-		}
-		dstFileDir := filepath.Dir(dstFile)
+	layers := d.appLayers(app)
+	rules, ok := d.fileRules[app]
+	if !ok {
+		rules = ReadFileRules(layers)
+		d.fileRules[app] = rules
+	}
+	owners := ownership{}
+	d.ownerships[inst.Name] = owners
+	for _, appFile := range rules.expandAppFiles(ListAppFiles(d.roots, layers, instVars), instVars) {
+		file := filepath.Join(appFile.layer.Dir, appFile.src)
+		dstFile := filepath.Join(instDir, appFile.dst)
 
-		dirPathToCreate := dstFileDir[len(instDir):]
-		dirPathToCreate = strings.TrimPrefix(dirPathToCreate, string(filepath.Separator))
-		var pathComponentsToCreate []string
-		if dirPathToCreate != "" {
-			pathComponentsToCreate = strings.Split(dirPathToCreate, string(filepath.Separator))
-		}
-		pathsToCreate := make([]string, len(pathComponentsToCreate))
-		for i := 0; i < len(pathComponentsToCreate); i++ {
-			if i == 0 {
-				pathsToCreate[0] = pathComponentsToCreate[0]
+		var rendered *bytes.Buffer
+		if appFile.isTemplate {
+			rendered = &bytes.Buffer{}
+			t := d.parseTemplate(app, file)
+			err = t.Execute(rendered, appFile.vars)
+			if rtemplate.IsSkipped(err) {
 				continue
 			}
-			pathsToCreate[i] = filepath.Join(pathsToCreate[i-1], pathComponentsToCreate[i])
+			if err != nil {
+				if substErr := d.substitutionErrors[inst.Name]; substErr != nil {
+					panic(rtemplate.NewErrExec(dstFile, fmt.Sprintf("packInstance %s. Maybe because of: %s", inst.Name, substErr), err))
+				} else {
+					panic(rtemplate.NewErrExec(dstFile, fmt.Sprintf("packInstance %s.", inst.Name), err))
+				}
+			}
 		}
-		for _, pathToCreate := range pathsToCreate {
-			oldPath := filepath.Join("apps", inst.App, pathToCreate)
-			newPath := filepath.Join(instDir, pathToCreate)
+
+		// names of directories may be rendered, but their number is the same,
+		// so permissions are taken from the source directory at the same depth
+		// of the layer providing the file
+		srcComponents := strings.Split(appFile.src, string(filepath.Separator))
+		dstComponents := strings.Split(appFile.dst, string(filepath.Separator))
+		for i := 1; i < len(dstComponents); i++ {
+			oldPath := filepath.Join(appFile.layer.Dir, filepath.Join(srcComponents[:i]...))
+			newPath := filepath.Join(instDir, filepath.Join(dstComponents[:i]...))
 			if fsys.DoesDirExists(newPath) {
 				continue
 			}
@@ -281,36 +331,36 @@ func (d *Deployer) packInstance(inst *inventory.Instance, r *SingleReport) {
 		}
 
 		defer dstFileHandle.Close()
-		if !isTemplate {
+
+		attrs := rules.attrsFor(appFile.dst, appFile.vars)
+		if mode, ok := attrs.parseMode(appFile.dst); ok {
+			if err := dstFileHandle.Chmod(mode); err != nil {
+				panic(err)
+			}
+		}
+		owners.add(filepath.ToSlash(appFile.dst), attrs)
+
+		var src io.Reader = rendered
+		if !appFile.isTemplate {
 			f, err := os.Open(file)
 			if err != nil {
 				panic(err)
 			}
 			defer f.Close()
-			_, err = io.Copy(dstFileHandle, f)
-			if err != nil {
-				panic(rerrors.NewErrIo(
-					fmt.Sprintf("%s OR %s", file, dstFile),
-					fmt.Sprintf("Copying %s to %s", file, dstFile),
-					err,
-				),
-				)
-			}
-			continue
+			src = f
 		}
-		t := d.parseTemplate(file)
-		err = t.Execute(dstFileHandle, d.resolvedInstanceVars[inst.Name])
+		_, err = io.Copy(dstFileHandle, src)
 		if err != nil {
-			if substErr := d.substitutionErrors[inst.Name]; substErr != nil {
-				panic(rtemplate.NewErrExec(dstFile, fmt.Sprintf("packInstance %s. Maybe because of: %s", inst.Name, substErr), err))
-			} else {
-				panic(rtemplate.NewErrExec(dstFile, fmt.Sprintf("packInstance %s.", inst.Name), err))
-			}
+			panic(rerrors.NewErrIo(
+				fmt.Sprintf("%s OR %s", file, dstFile),
+				fmt.Sprintf("Copying %s to %s", file, dstFile),
+				err,
+			),
+			)
 		}
-		continue
 	}
 
-	sh.MustDoSilentlyf("tar -C %s -cvpf %s.tar .", instDir, instDir)
+	packTar(instDir, ".", instDir+".tar")
 
 	if err := os.RemoveAll(instDir); err != nil {
 		panic(err)
@@ -0,0 +1,378 @@
+package deployer
+
+import (
+	"fmt"
+	"golden/pkg/apps"
+	"golden/pkg/fsys"
+	"golden/pkg/ignore"
+	"golden/pkg/rerrors"
+	"golden/pkg/root"
+	"golden/pkg/rtemplate"
+	"golden/pkg/ryaml"
+	"golden/pkg/sh"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FileRule overrides attributes of deployed files matching Path, a glob
+// over paths relative to the app directory after ".gotmpl" is stripped.
+// Owner, Group and Mode are templates over instance variables.
+// Later rules override earlier ones attribute by attribute.
+//
+// ForEach names a list or a map variable, e. g. "nginx.vhosts". A file
+// matching Path before its name is rendered is deployed once per item with
+// the item available as ._item_ (and ._key_ for maps), e. g.
+// "vhosts/{{ ._item_.name }}.conf.gotmpl" for a list of maps with a "name"
+// key. Keys of an item are not lifted to the top level, so they never
+// shadow instance variables.
+//
+// For example:
+//
+//	# apps/<app>/.golden/files.yml
+//	- path: secrets.conf
+//	  owner: "{{ .user }}"
+//	  group: app
+//	  mode: "0600"
+//	- path: vhosts/*.conf
+//	  for_each: vhosts
+type FileRule struct {
+	Path    string `yaml:"path"`
+	Owner   string `yaml:"owner"`
+	Group   string `yaml:"group"`
+	Mode    string `yaml:"mode"`
+	ForEach string `yaml:"for_each"`
+
+	source string
+	// glob is Path compiled on the first match
+	glob *fsys.Glob
+}
+
+type FileRules []*FileRule
+
+// ReadFileRules reads rules of all layers of an app, so that rules
+// of an app follow and override rules of apps it is composed of.
+func ReadFileRules(layers []*apps.Layer) FileRules {
+	rules := FileRules{}
+	for _, layer := range layers {
+		filenamesList := []string{}
+		lists := ryaml.ReadYamlRecursive(filepath.Join(layer.Dir, apps.MetaDir, "files"), func(filename string) interface{} {
+			filenamesList = append(filenamesList, filename)
+			return &FileRules{}
+		})
+		for i, l := range lists {
+			for _, rule := range *l.(*FileRules) {
+				rule.source = filenamesList[i]
+				rules = append(rules, rule)
+			}
+		}
+	}
+	return rules
+}
+
+type fileAttrs struct {
+	owner string
+	group string
+	mode  string
+}
+
+// attrsFor returns attributes of a file with all matching rules applied.
+func (rules FileRules) attrsFor(relPath string, vars map[string]interface{}) fileAttrs {
+	attrs := fileAttrs{}
+	for _, rule := range rules {
+		if !rule.matches(relPath) {
+			continue
+		}
+		for _, field := range []struct {
+			name string
+			src  string
+			dst  *string
+		}{
+			{"owner", rule.Owner, &attrs.owner},
+			{"group", rule.Group, &attrs.group},
+			{"mode", rule.Mode, &attrs.mode},
+		} {
+			if field.src == "" {
+				continue
+			}
+			*field.dst = rule.exec(field.name, field.src, vars)
+		}
+	}
+	return attrs
+}
+
+func (rule *FileRule) matches(relPath string) bool {
+	if rule.glob == nil {
+		glob, err := fsys.CompileGlob(rule.Path)
+		if err != nil {
+			panic(rerrors.NewErrStringf("%s: invalid path %s: %s", rule.source, rule.Path, err))
+		}
+		rule.glob = glob
+	}
+	return rule.glob.Match(filepath.ToSlash(relPath))
+}
+
+func (rule *FileRule) exec(field, value string, vars map[string]interface{}) string {
+	if !strings.Contains(value, "{{") {
+		return value
+	}
+	ctx := fmt.Sprintf("%s: %s: %s", rule.source, rule.Path, field)
+	t, err := rtemplate.New(ctx).Option("missingkey=error").Parse(value)
+	if err != nil {
+		panic(rtemplate.NewErrParse(ctx, err))
+	}
+	out, err := rtemplate.ExecToString(t, vars)
+	if err != nil {
+		panic(rtemplate.NewErrExec(rule.source, rule.Path+": "+field, err))
+	}
+	return strings.TrimSpace(out)
+}
+
+func (a fileAttrs) parseMode(relPath string) (os.FileMode, bool) {
+	if a.mode == "" {
+		return 0, false
+	}
+	mode, err := strconv.ParseUint(a.mode, 8, 32)
+	if err != nil || mode > 07777 {
+		panic(rerrors.NewErrStringf("invalid mode %q for %s: must be octal, e. g. 0640", a.mode, relPath))
+	}
+	fileMode := os.FileMode(mode & 0777)
+	for bit, flag := range map[uint64]os.FileMode{04000: os.ModeSetuid, 02000: os.ModeSetgid, 01000: os.ModeSticky} {
+		if mode&bit != 0 {
+			fileMode |= flag
+		}
+	}
+	return fileMode, true
+}
+
+// ownership collects files of an instance to chown after extraction,
+// grouped by "owner:group".
+type ownership map[string][]string
+
+func (o ownership) add(relPath string, a fileAttrs) {
+	if a.owner == "" && a.group == "" {
+		return
+	}
+	key := a.owner
+	if a.group != "" {
+		key += ":" + a.group
+	}
+	o[key] = append(o[key], relPath)
+}
+
+// commands returns chown commands to run within the deploy directory.
+func (o ownership) commands() []string {
+	keys := make([]string, 0, len(o))
+	for k := range o {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	cmds := make([]string, 0, len(keys))
+	for _, k := range keys {
+		files := make([]string, 0, len(o[k]))
+		for _, f := range o[k] {
+			files = append(files, sh.Quote(f))
+		}
+		cmds = append(cmds, fmt.Sprintf("chown %s %s", sh.Quote(k), strings.Join(files, " ")))
+	}
+	return cmds
+}
+
+// SourceFile is a file of one of the layers of an app.
+type SourceFile struct {
+	Layer *apps.Layer
+	// Path is relative to the directory of the layer
+	Path string
+}
+
+// ListAppFiles returns files of an app composed of layers to be deployed
+// for an instance with vars. A file of a layer overrides a file of a lower
+// layer with the same path, with or without ".gotmpl". Files of apps.MetaDir
+// and files ignored by .goldenignore of the roots and of any layer are left out.
+func ListAppFiles(rs root.Roots, layers []*apps.Layer, vars map[string]interface{}) []*SourceFile {
+	matcher := ignore.New()
+	for _, file := range rs.Paths(ignore.FileName) {
+		matcher.MustAddFile(file, vars)
+	}
+	for _, layer := range layers {
+		matcher.MustAddFile(filepath.Join(layer.Dir, ignore.FileName), vars)
+	}
+
+	byName := map[string]*SourceFile{}
+	for _, layer := range layers {
+		files, err := fsys.GetAllFilesRecursive(layer.Dir)
+		if err != nil {
+			panic(err)
+		}
+		for _, file := range files {
+			relPath, err := filepath.Rel(layer.Dir, file)
+			if err != nil {
+				panic(err)
+			}
+			relPath = filepath.ToSlash(relPath)
+			if relPath == ignore.FileName || strings.HasPrefix(relPath, apps.MetaDir+"/") {
+				continue
+			}
+			if matcher.Ignored(relPath, false) {
+				continue
+			}
+			name, _ := stripTemplateSuffix(relPath)
+			byName[name] = &SourceFile{Layer: layer, Path: filepath.FromSlash(relPath)}
+		}
+	}
+
+	out := make([]*SourceFile, 0, len(byName))
+	for _, f := range byName {
+		out = append(out, f)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Path < out[j].Path })
+	return out
+}
+
+// stripTemplateSuffix returns a name of a deployed file: ".gotmpl" is
+// stripped from templates and "_" from ".gotmpl_" files, which are not
+// templates.
+func stripTemplateSuffix(path string) (name string, isTemplate bool) {
+	if strings.HasSuffix(path, ".gotmpl") {
+		return strings.TrimSuffix(path, ".gotmpl"), true
+	}
+	if strings.HasSuffix(path, ".gotmpl_") {
+		return strings.TrimSuffix(path, "_"), false
+	}
+	return path, false
+}
+
+// appFile is a single file to deploy for an instance.
+type appFile struct {
+	layer *apps.Layer
+	// src is relative to the directory of the layer
+	src string
+	// dst is relative to the instance directory with names rendered
+	// and ".gotmpl" stripped
+	dst        string
+	isTemplate bool
+	vars       map[string]interface{}
+}
+
+// expandAppFiles renders names of app files, repeats files of for_each
+// rules and leaves out files whose names are rendered empty or call skip.
+func (rules FileRules) expandAppFiles(files []*SourceFile, vars map[string]interface{}) []*appFile {
+	out := make([]*appFile, 0, len(files))
+	seen := map[string]string{}
+	for _, f := range files {
+		src := filepath.ToSlash(f.Path)
+		name, isTemplate := stripTemplateSuffix(src)
+		for _, itemVars := range rules.forEachVars(name, vars) {
+			dst, ok := renderName(filepath.Join(f.Layer.Dir, f.Path), name, itemVars)
+			if !ok {
+				continue
+			}
+			if other, ok := seen[dst]; ok {
+				panic(rerrors.NewErrStringf("both %s and %s are deployed as %s", other, filepath.Join(f.Layer.Dir, f.Path), dst))
+			}
+			seen[dst] = filepath.Join(f.Layer.Dir, f.Path)
+			out = append(out, &appFile{
+				layer:      f.Layer,
+				src:        f.Path,
+				dst:        filepath.FromSlash(dst),
+				isTemplate: isTemplate,
+				vars:       itemVars,
+			})
+		}
+	}
+	return out
+}
+
+// forEachVars returns vars for every copy of a file. The last for_each
+// rule matching the file wins.
+func (rules FileRules) forEachVars(name string, vars map[string]interface{}) []map[string]interface{} {
+	var forEach *FileRule
+	for _, rule := range rules {
+		if rule.ForEach != "" && rule.matches(name) {
+			forEach = rule
+		}
+	}
+	if forEach == nil {
+		return []map[string]interface{}{vars}
+	}
+
+	var value interface{} = vars
+	for _, key := range strings.Split(forEach.ForEach, ".") {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			value = nil
+			break
+		}
+		value = m[key]
+	}
+
+	out := []map[string]interface{}{}
+	switch items := value.(type) {
+	case []interface{}:
+		for _, item := range items {
+			out = append(out, withItem(vars, item, nil))
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(items))
+		for k := range items {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			out = append(out, withItem(vars, items[k], k))
+		}
+	case nil:
+		panic(rerrors.NewErrStringf("%s: for_each of %s: variable %s is not defined", forEach.source, forEach.Path, forEach.ForEach))
+	default:
+		panic(rerrors.NewErrStringf("%s: for_each of %s: variable %s is neither a list nor a map", forEach.source, forEach.Path, forEach.ForEach))
+	}
+	return out
+}
+
+func withItem(vars map[string]interface{}, item interface{}, key interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(vars)+2)
+	for k, v := range vars {
+		out[k] = v
+	}
+	out["_item_"] = item
+	if key != nil {
+		out["_key_"] = key
+	}
+	return out
+}
+
+// renderName executes templates within components of a slash separated
+// name. It returns false if any of them is rendered empty or calls skip.
+func renderName(src, name string, vars map[string]interface{}) (string, bool) {
+	if !strings.Contains(name, "{{") {
+		return name, true
+	}
+	components := strings.Split(name, "/")
+	for i, c := range components {
+		if !strings.Contains(c, "{{") {
+			continue
+		}
+		t, err := rtemplate.New(src).Option("missingkey=error").Parse(c)
+		if err != nil {
+			panic(rtemplate.NewErrParse(src, err))
+		}
+		out, err := rtemplate.ExecToString(t, vars)
+		if rtemplate.IsSkipped(err) {
+			return "", false
+		}
+		if err != nil {
+			panic(rtemplate.NewErrExec(src, "file name", err))
+		}
+		out = strings.TrimSpace(out)
+		if out == "" {
+			return "", false
+		}
+		if strings.Contains(out, "/") || out == "." || out == ".." {
+			panic(rerrors.NewErrStringf("%s: name %q rendered as %q is not a valid file name", src, c, out))
+		}
+		components[i] = out
+	}
+	return strings.Join(components, "/"), true
+}
@@ -0,0 +1,39 @@
+package deployer
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestForEachVarsKeepsItemsUnderTheirKey(t *testing.T) {
+	vars := map[string]interface{}{
+		"name": "instance",
+		"vhosts": []interface{}{
+			map[string]interface{}{"name": "a"},
+		},
+		"ports": map[string]interface{}{"http": 80},
+	}
+	rules := FileRules{
+		{Path: "vhosts/*", ForEach: "vhosts"},
+		{Path: "ports/*", ForEach: "ports"},
+	}
+
+	got := rules.forEachVars("vhosts/x.conf", vars)
+	if len(got) != 1 {
+		t.Fatalf("got %d copies, want 1", len(got))
+	}
+	if got[0]["name"] != "instance" {
+		t.Errorf("name = %v, want the instance variable not to be shadowed", got[0]["name"])
+	}
+	if !reflect.DeepEqual(got[0]["_item_"], map[string]interface{}{"name": "a"}) {
+		t.Errorf("_item_ = %v, want the vhost", got[0]["_item_"])
+	}
+	if _, ok := got[0]["_key_"]; ok {
+		t.Errorf("_key_ = %v, want none for a list", got[0]["_key_"])
+	}
+
+	got = rules.forEachVars("ports/x.conf", vars)
+	if len(got) != 1 || got[0]["_key_"] != "http" || got[0]["_item_"] != 80 {
+		t.Errorf("got %v, want _key_ http and _item_ 80", got)
+	}
+}
@@ -0,0 +1,99 @@
+package deployer
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"golden/pkg/rerrors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// packTar packs path within dir into an archive with entries in walk order,
+// i. e. sorted by name, and with fixed times and owners, so that the same
+// files are packed into the same bytes every run and whatever tar is
+// installed locally. Deployed files get times of extraction by -m, their
+// owners are set by file rules.
+func packTar(dir, path, archive string) {
+	writeArchive(archive, func(w io.Writer) { writeTar(w, dir, path) })
+}
+
+// packTarGz is packTar compressed into archive.tar.gz without a name
+// and a time in the gzip header.
+func packTarGz(dir, path, archive string) {
+	writeArchive(archive, func(w io.Writer) {
+		gz := gzip.NewWriter(w)
+		writeTar(gz, dir, path)
+		if err := gz.Close(); err != nil {
+			panic(rerrors.NewErrIo(archive, "packing", err))
+		}
+	})
+}
+
+func writeArchive(archive string, write func(w io.Writer)) {
+	f, err := os.Create(archive)
+	if err != nil {
+		panic(rerrors.NewErrIo(archive, "packing", err))
+	}
+	defer f.Close()
+	write(f)
+	if err := f.Close(); err != nil {
+		panic(rerrors.NewErrIo(archive, "packing", err))
+	}
+}
+
+func writeTar(w io.Writer, dir, path string) {
+	tw := tar.NewWriter(w)
+	err := filepath.WalkDir(filepath.Join(dir, path), func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		link := ""
+		if info.Mode()&fs.ModeSymlink != 0 {
+			if link, err = os.Readlink(file); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(name)
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		hdr.ModTime = time.Unix(0, 0)
+		hdr.AccessTime, hdr.ChangeTime = time.Time{}, time.Time{}
+		hdr.Uid, hdr.Gid = 0, 0
+		hdr.Uname, hdr.Gname = "", ""
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		src, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		_, err = io.Copy(tw, src)
+		return err
+	})
+	if err == nil {
+		err = tw.Close()
+	}
+	if err != nil {
+		panic(rerrors.NewErrIo(filepath.Join(dir, path), "packing", err))
+	}
+}
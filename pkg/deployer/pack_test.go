@@ -0,0 +1,140 @@
+package deployer
+
+import (
+	"bytes"
+	"golden/pkg/inventory"
+	"golden/pkg/root"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPackIsReproducible(t *testing.T) {
+	files := []string{"b.conf", "a/z.txt", "a/b/c.txt", "c", "a/a.txt"}
+
+	// the same files are written in a different order with different times
+	pack := func(order []string, mtime time.Time) (tarData, gzData []byte) {
+		dir := t.TempDir()
+		inst := filepath.Join(dir, "host", "inst")
+		for _, f := range order {
+			path := filepath.Join(inst, f)
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, []byte("content of "+f), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chtimes(path, mtime, mtime); err != nil {
+				t.Fatal(err)
+			}
+		}
+		packTar(inst, ".", inst+".tar")
+		tarData, err := os.ReadFile(inst + ".tar")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.RemoveAll(inst); err != nil {
+			t.Fatal(err)
+		}
+		packTarGz(dir, "host", filepath.Join(dir, "host.tar.gz"))
+		gzData, err = os.ReadFile(filepath.Join(dir, "host.tar.gz"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tarData, gzData
+	}
+
+	reversed := make([]string, len(files))
+	for i, f := range files {
+		reversed[len(files)-1-i] = f
+	}
+	tar1, gz1 := pack(files, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	tar2, gz2 := pack(reversed, time.Date(2021, 6, 1, 12, 30, 0, 0, time.UTC))
+
+	if !bytes.Equal(tar1, tar2) {
+		t.Error("instance archives of the same files differ")
+	}
+	if !bytes.Equal(gz1, gz2) {
+		t.Error("host archives of the same files differ")
+	}
+}
+
+func TestDeployIsReproducible(t *testing.T) {
+	// local hosts of the current user are deployed to with the shell,
+	// the remote temporary directory is created within the home directory
+	t.Setenv("HOME", t.TempDir())
+	src := t.TempDir()
+	for file, content := range map[string]string{
+		"hosts.yml":         "h1: {}\nh2: {}\nh3: {}\n",
+		"instances.yml":     "c: {app: app, host: h2}\na: {app: app, host: h2}\nb: {app: app, host: h1}\nd: {app: app, host: h3}\n",
+		"apps/app/a.conf":   "a",
+		"apps/app/b/c.conf": "c",
+	} {
+		path := filepath.Join(src, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rs := root.Roots{src}
+	inv := inventory.ReadInventory(rs, "")
+	names := []string{"d", "c", "b", "a"}
+
+	deploy := func(order []string) ([][]string, map[string]string) {
+		target := t.TempDir()
+		insts := []*inventory.Instance{}
+		vars := map[string]map[string]interface{}{}
+		for _, name := range order {
+			inst := *inv.GetAllInstances()[name]
+			inst.InstallPrefix = filepath.Join(target, name)
+			insts = append(insts, &inst)
+			vars[name] = map[string]interface{}{}
+		}
+		report := New(rs, vars, nil, inv).Deploy(insts)
+
+		// String sums up hosts into the summary, times spent differ every run
+		_ = report.String()
+		rows := [][]string{}
+		for _, r := range report.SummaryAndHostReps {
+			rows = append(rows, r.ToColumns()[:ReportColumnsCount-2])
+		}
+		deployed := map[string]string{}
+		err := filepath.Walk(target, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+			content, err := os.ReadFile(path)
+			rel, _ := filepath.Rel(target, path)
+			deployed[rel] = string(content)
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rows, deployed
+	}
+
+	rows1, deployed1 := deploy(names)
+	reversed := make([]string, len(names))
+	for i, name := range names {
+		reversed[len(names)-1-i] = name
+	}
+	rows2, deployed2 := deploy(reversed)
+
+	wantRows := [][]string{
+		{"Summary", "4", "4", "0", "0"},
+		{"h1", "1", "1", "0", "0"},
+		{"h2", "2", "2", "0", "0"},
+		{"h3", "1", "1", "0", "0"},
+	}
+	if !reflect.DeepEqual(rows1, wantRows) || !reflect.DeepEqual(rows2, wantRows) {
+		t.Errorf("got reports %v and %v, want %v", rows1, rows2, wantRows)
+	}
+	if len(deployed1) != 8 || !reflect.DeepEqual(deployed1, deployed2) {
+		t.Errorf("got deployed files %v and %v, want the same 8 files", deployed1, deployed2)
+	}
+}
@@ -0,0 +1,75 @@
+package deployer
+
+import (
+	"golden/pkg/apps"
+	"golden/pkg/fsys"
+	"golden/pkg/rerrors"
+	"golden/pkg/rtemplate"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// PartialsDir holds templates available to every template of every app.
+// Partials of later roots override those of earlier ones. Apps may have
+// their own partials in apps/<app>/.golden/templates, which override the
+// common ones and those of apps they are composed of.
+//
+// A partial is available by its path relative to the partials directory
+// without ".gotmpl", e. g. templates/tls_block.gotmpl is included with
+// {{ template "tls_block" . }}. Templates defined within partials with
+// {{ define }} are available too.
+const PartialsDir = "templates"
+
+// partials returns a template set with partials of an app.
+// It must be cloned before adding templates to it.
+func (d *Deployer) partials(app string) *template.Template {
+	if t, ok := d.parsedPartials[app]; ok {
+		return t
+	}
+	t := rtemplate.New("").Funcs(d.funcs).Option("missingkey=error")
+	for _, p := range d.partialFiles(app) {
+		content, err := os.ReadFile(p.file)
+		if err != nil {
+			panic(rerrors.NewErrIo(p.file, "reading a partial", err))
+		}
+		if _, err := t.New(p.name).Parse(string(content)); err != nil {
+			panic(rtemplate.NewErrParse(p.file, err))
+		}
+	}
+	d.parsedPartials[app] = t
+	return t
+}
+
+type partialFile struct {
+	name string
+	file string
+}
+
+// partialFiles lists partials of an app, a later partial overrides
+// an earlier one of the same name.
+func (d *Deployer) partialFiles(app string) []partialFile {
+	dirs := d.roots.Paths(PartialsDir)
+	for _, layer := range d.appLayers(app) {
+		dirs = append(dirs, filepath.Join(layer.Dir, apps.MetaDir, PartialsDir))
+	}
+	out := []partialFile{}
+	for _, dir := range dirs {
+		files, err := fsys.GetAllFilesRecursive(dir)
+		if err != nil {
+			panic(err)
+		}
+		sort.Strings(files)
+		for _, file := range files {
+			name, err := filepath.Rel(dir, file)
+			if err != nil {
+				panic(err)
+			}
+			name = strings.TrimSuffix(filepath.ToSlash(name), ".gotmpl")
+			out = append(out, partialFile{name, file})
+		}
+	}
+	return out
+}
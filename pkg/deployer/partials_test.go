@@ -0,0 +1,40 @@
+package deployer
+
+import (
+	"bytes"
+	"golden/pkg/apps"
+	"golden/pkg/root"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAppsExtendingOneAppUseTheirOwnPartials(t *testing.T) {
+	dir := t.TempDir()
+	for file, content := range map[string]string{
+		"apps/base/greeting.conf.gotmpl":                 `{{ template "name" . }}`,
+		"apps/a/.golden/app.yml":                         "extends: base\n",
+		"apps/a/.golden/" + PartialsDir + "/name.gotmpl": "a",
+		"apps/b/.golden/app.yml":                         "extends: base\n",
+		"apps/b/.golden/" + PartialsDir + "/name.gotmpl": "b",
+	} {
+		path := filepath.Join(dir, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d := New(root.Roots{dir}, nil, nil, nil)
+	file := filepath.Join(dir, apps.Dir, "base", "greeting.conf.gotmpl")
+	for _, app := range []string{"a", "b", "a"} {
+		out := &bytes.Buffer{}
+		if err := d.parseTemplate(app, file).Execute(out, nil); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != app {
+			t.Errorf("app %s rendered %q, want %q", app, out.String(), app)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package deployer
+
+import (
+	"golden/pkg/ignore"
+	"golden/pkg/inventory"
+	"golden/pkg/rtemplate"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// Template is a template used to deploy an instance, parsed but never
+// executed, e. g. to be checked statically.
+type Template struct {
+	// Name is a file the template is defined in, with a context if
+	// the template is a part of the file
+	Name string
+	Tree *parse.Tree
+	// Partials the template may include, by names
+	Partials map[string]*parse.Tree
+	// Err is set if the template does not parse
+	Err error
+	// Vars the template is executed with, once per item of for_each
+	Vars []map[string]interface{}
+}
+
+// Templates returns all templates used to deploy an instance: ignore
+// files, file rules, templated file names and .gotmpl files. Partials
+// are not executed on their own, they are Partials of the templates,
+// unless they do not parse.
+func (d *Deployer) Templates(inst *inventory.Instance) []*Template {
+	instVars := d.resolvedInstanceVars[inst.Name]
+	layers := d.appLayers(inst.App)
+	rules, ok := d.fileRules[inst.App]
+	if !ok {
+		rules = ReadFileRules(layers)
+		d.fileRules[inst.App] = rules
+	}
+
+	out := []*Template{}
+	// templates of {{ define }} are parsed into separate trees
+	parseText := func(name, text string, partials map[string]*parse.Tree) *template.Template {
+		t, err := rtemplate.New(name).Parse(text)
+		if err != nil {
+			out = append(out, &Template{Name: name, Err: err})
+			return nil
+		}
+		for _, defined := range t.Templates() {
+			if defined.Tree != nil && defined.Name() != name {
+				partials[defined.Name()] = defined.Tree
+			}
+		}
+		return t
+	}
+	readFile := func(file string) (string, bool) {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			out = append(out, &Template{Name: file, Err: err})
+			return "", false
+		}
+		return string(content), true
+	}
+
+	partials := map[string]*parse.Tree{}
+	for _, p := range d.partialFiles(inst.App) {
+		content, ok := readFile(p.file)
+		if !ok {
+			continue
+		}
+		if t := parseText(p.file, content, partials); t != nil && t.Tree != nil {
+			partials[p.name] = t.Tree
+		}
+	}
+
+	add := func(name, text string, vars []map[string]interface{}) {
+		own := make(map[string]*parse.Tree, len(partials))
+		for k, v := range partials {
+			own[k] = v
+		}
+		if t := parseText(name, text, own); t != nil {
+			out = append(out, &Template{Name: name, Tree: t.Tree, Partials: own, Vars: vars})
+		}
+	}
+	addFile := func(file string, vars []map[string]interface{}) {
+		if content, ok := readFile(file); ok {
+			add(file, content, vars)
+		}
+	}
+	instOnly := []map[string]interface{}{instVars}
+
+	ignoreFiles := d.roots.Paths(ignore.FileName)
+	for _, layer := range layers {
+		ignoreFiles = append(ignoreFiles, filepath.Join(layer.Dir, ignore.FileName))
+	}
+	for _, file := range ignoreFiles {
+		if _, err := os.Stat(file); err == nil {
+			addFile(file, instOnly)
+		}
+	}
+	for _, rule := range rules {
+		if rule.ForEach != "" {
+			add(rule.source+": "+rule.Path+": for_each", "{{ ."+rule.ForEach+" }}", instOnly)
+		}
+		for _, field := range [][2]string{{"owner", rule.Owner}, {"group", rule.Group}, {"mode", rule.Mode}} {
+			if strings.Contains(field[1], "{{") {
+				add(rule.source+": "+rule.Path+": "+field[0], field[1], instOnly)
+			}
+		}
+	}
+	for _, f := range ListAppFiles(d.roots, layers, instVars) {
+		file := filepath.Join(f.Layer.Dir, f.Path)
+		name, isTemplate := stripTemplateSuffix(filepath.ToSlash(f.Path))
+		vars := rules.forEachVars(name, instVars)
+		if strings.Contains(name, "{{") {
+			add(file+": file name", name, vars)
+		}
+		if isTemplate {
+			addFile(file, vars)
+		}
+	}
+	return out
+}
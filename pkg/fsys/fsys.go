@@ -5,6 +5,7 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
 )
 
 func DoesDirExists(dir string) bool {
@@ -37,54 +38,46 @@ func DoesFileExists(filename string) bool {
 	return !stat.IsDir()
 }
 
+// GetDirs returns directories within dir sorted by name.
 func GetDirs(dir string) ([]string, error) {
 	files := make([]string, 0, 10)
-	f, err := os.Open(dir)
+	entries, err := os.ReadDir(dir)
 	if err != nil {
 		return files, err
 	}
-	fileInfo, err := f.Readdir(-1)
-	_ = f.Close()
 
-	if err != nil {
-		return files, err
-	}
-
-	for _, file := range fileInfo {
-		if !file.IsDir() {
+	for _, entry := range entries {
+		if !entry.IsDir() {
 			continue
 		}
 
-		files = append(files, filepath.Join(dir, file.Name()))
+		files = append(files, filepath.Join(dir, entry.Name()))
 	}
 
 	return files, nil
 }
 
+// GetFiles returns names of files within dir sorted.
 func GetFiles(dir string) ([]string, error) {
 	files := make([]string, 0, 10)
-	f, err := os.Open(dir)
-	if err != nil {
-		return files, err
-	}
-	fileInfo, err := f.Readdir(-1)
-	_ = f.Close()
-
+	entries, err := os.ReadDir(dir)
 	if err != nil {
 		return files, err
 	}
 
-	for _, file := range fileInfo {
-		if file.IsDir() {
+	for _, entry := range entries {
+		if entry.IsDir() {
 			continue
 		}
 
-		files = append(files, file.Name())
+		files = append(files, entry.Name())
 	}
 
 	return files, nil
 }
 
+// GetAllFilesRecursive returns sorted paths of all files within dir
+// and its subdirectories.
 func GetAllFilesRecursive(dir string) ([]string, error) {
 	files := make([]string, 0, 10)
 	bfsList := []string{dir}
@@ -111,6 +104,7 @@ func GetAllFilesRecursive(dir string) ([]string, error) {
 			files = append(files, filepath.Join(dir, file.Name()))
 		}
 	}
+	sort.Strings(files)
 
 	return files, nil
 }
@@ -0,0 +1,85 @@
+package fsys
+
+import (
+	"regexp"
+	"strings"
+)
+
+// GlobToRegexp converts a glob to an anchored regular expression over
+// slash separated paths:
+//
+//	pattern  matches
+//	*        any characters except "/"
+//	?        any character except "/"
+//	[abc]    a character class, [!abc] negates it
+//	**       any characters including "/", "**/" also matches zero directories
+func GlobToRegexp(glob string) (*regexp.Regexp, error) {
+	b := strings.Builder{}
+	b.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch c {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				i++
+				if i+1 < len(glob) && glob[i+1] == '/' {
+					i++
+					b.WriteString("(?:.*/)?")
+					continue
+				}
+				b.WriteString(".*")
+				continue
+			}
+			b.WriteString("[^/]*")
+		case '?':
+			b.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end == -1 {
+				b.WriteString(regexp.QuoteMeta("["))
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+				b.WriteString(regexp.QuoteMeta(string(glob[i])))
+			}
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+// Glob matches slash separated relative paths. A glob without "/"
+// is matched against the base name at any depth.
+type Glob struct {
+	re       *regexp.Regexp
+	baseName bool
+}
+
+// CompileGlob compiles a glob once to match many paths.
+func CompileGlob(glob string) (*Glob, error) {
+	re, err := GlobToRegexp(strings.TrimPrefix(glob, "/"))
+	if err != nil {
+		return nil, err
+	}
+	return &Glob{re: re, baseName: !strings.Contains(glob, "/")}, nil
+}
+
+// Match reports whether a slash separated relative path matches the glob.
+func (g *Glob) Match(path string) bool {
+	if g.baseName {
+		if i := strings.LastIndexByte(path, '/'); i != -1 {
+			path = path[i+1:]
+		}
+	}
+	return g.re.MatchString(path)
+}
@@ -0,0 +1,41 @@
+package fsys
+
+import "testing"
+
+func TestGlob(t *testing.T) {
+	for _, tc := range []struct {
+		glob, path string
+		want       bool
+	}{
+		{"*.conf", "a.conf", true},
+		{"*.conf", "dir/a.conf", true},
+		{"*.conf", "a.conf.bak", false},
+		{"a?.conf", "ab.conf", true},
+		{"a?.conf", "a/.conf", false},
+		{"[ab].txt", "b.txt", true},
+		{"[!ab].txt", "b.txt", false},
+		{"[!ab].txt", "c.txt", true},
+		{"[ab", "[ab", true},
+		{`\*.txt`, "*.txt", true},
+		{`\*.txt`, "a.txt", false},
+		{"dir/*.conf", "dir/a.conf", true},
+		{"dir/*.conf", "dir/sub/a.conf", false},
+		{"dir/*.conf", "other/dir/a.conf", false},
+		{"/dir/*.conf", "dir/a.conf", true},
+		{"dir/**", "dir/sub/a.conf", true},
+		{"dir/**/a.conf", "dir/a.conf", true},
+		{"dir/**/a.conf", "dir/x/y/a.conf", true},
+		{"dir/**/a.conf", "dir/x/b.conf", false},
+		{"**/a.conf", "a.conf", true},
+		{"a**b/c", "axx/yyb/c", true},
+	} {
+		g, err := CompileGlob(tc.glob)
+		if err != nil {
+			t.Errorf("%s: %s", tc.glob, err)
+			continue
+		}
+		if got := g.Match(tc.path); got != tc.want {
+			t.Errorf("%q matches %q: got %v, want %v", tc.glob, tc.path, got, tc.want)
+		}
+	}
+}
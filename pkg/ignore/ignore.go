@@ -0,0 +1,140 @@
+package ignore
+
+import (
+	"fmt"
+	"golden/pkg/fsys"
+	"golden/pkg/rerrors"
+	"golden/pkg/rtemplate"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// FileName of ignore files. They are read from the root directory and
+// from an app directory, patterns of both are relative to the app
+// directory. They follow gitignore syntax:
+// "#" comments, "!" negation, trailing "/" for directories only,
+// a leading or middle "/" anchors a pattern, "*", "?", "[...]" and "**".
+const FileName = ".goldenignore"
+
+type rule struct {
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// Matcher decides whether a path relative to a directory is ignored.
+// The last matching rule wins. Nothing inside an ignored directory
+// can be re-included, as in git.
+type Matcher struct {
+	rules []*rule
+}
+
+func New() *Matcher {
+	return &Matcher{}
+}
+
+type ErrPattern struct {
+	source  string
+	line    int
+	pattern string
+	raw     error
+}
+
+func (e *ErrPattern) NiceError() string {
+	return fmt.Sprintf("Invalid ignore pattern %q at %s:%d: %s", e.pattern, e.source, e.line, e.raw)
+}
+
+func (e *ErrPattern) Error() string {
+	return e.NiceError()
+}
+
+// Add parses rules from content of an ignore file.
+// source is used in errors only.
+func (m *Matcher) Add(content, source string) error {
+	for i, line := range strings.Split(content, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if !strings.HasSuffix(line, `\ `) {
+			line = strings.TrimRight(line, " \t")
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		r := &rule{}
+		pattern := line
+		if strings.HasPrefix(pattern, "!") {
+			r.negate = true
+			pattern = pattern[1:]
+		} else if strings.HasPrefix(pattern, `\!`) || strings.HasPrefix(pattern, `\#`) {
+			pattern = pattern[1:]
+		}
+		if strings.HasSuffix(pattern, "/") {
+			r.dirOnly = true
+			pattern = strings.TrimRight(pattern, "/")
+		}
+		anchored := strings.Contains(pattern, "/")
+		pattern = strings.TrimPrefix(pattern, "/")
+		if !anchored {
+			pattern = "**/" + pattern
+		}
+		re, err := fsys.GlobToRegexp(pattern)
+		if err != nil {
+			return &ErrPattern{source, i + 1, line, err}
+		}
+		r.re = re
+		m.rules = append(m.rules, r)
+	}
+	return nil
+}
+
+func (m *Matcher) match(path string, isDir bool) bool {
+	ignored := false
+	for _, r := range m.rules {
+		if r.dirOnly && !isDir {
+			continue
+		}
+		if r.re.MatchString(path) {
+			ignored = !r.negate
+		}
+	}
+	return ignored
+}
+
+// Ignored reports whether a slash separated path is ignored
+// either by itself or because one of its parent directories is.
+func (m *Matcher) Ignored(path string, isDir bool) bool {
+	if len(m.rules) == 0 {
+		return false
+	}
+	parts := strings.Split(path, "/")
+	for i := 1; i < len(parts); i++ {
+		if m.match(strings.Join(parts[:i], "/"), true) {
+			return true
+		}
+	}
+	return m.match(path, isDir)
+}
+
+// MustAddFile adds rules from an ignore file executed as a template over
+// vars, so that rules may depend on instance variables. A missing file
+// adds nothing.
+func (m *Matcher) MustAddFile(filename string, vars map[string]interface{}) {
+	if !fsys.DoesFileExists(filename) {
+		return
+	}
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		panic(rerrors.NewErrIo(filename, "reading ignore file", err))
+	}
+	t, err := rtemplate.New(filename).Option("missingkey=error").Parse(string(content))
+	if err != nil {
+		panic(rtemplate.NewErrParse(filename, err))
+	}
+	rendered, err := rtemplate.ExecToString(t, vars)
+	if err != nil {
+		panic(rtemplate.NewErrExec(filename, "ignore file", err))
+	}
+	if err := m.Add(rendered, filename); err != nil {
+		panic(err)
+	}
+}
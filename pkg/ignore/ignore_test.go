@@ -0,0 +1,51 @@
+package ignore
+
+import "testing"
+
+func TestMatcher(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		content string
+		path    string
+		isDir   bool
+		want    bool
+	}{
+		{"base name at any depth", "*.log", "a/b/x.log", false, true},
+		{"comment", "# *.log", "x.log", false, false},
+		{"escaped hash", `\#notes`, "#notes", false, true},
+		{"negation", "*.log\n!keep.log", "keep.log", false, false},
+		{"last rule wins", "!keep.log\n*.log", "keep.log", false, true},
+		{"escaped negation", `\!important`, "!important", false, true},
+		{"dir only matches a directory", "build/", "build", true, true},
+		{"dir only skips a file", "build/", "build", false, false},
+		{"dir only ignores contents", "build/", "build/out/a.o", false, true},
+		{"dir only at any depth", "build/", "src/build/a.o", false, true},
+		{"leading slash anchors", "/todo", "todo", false, true},
+		{"leading slash does not match deeper", "/todo", "docs/todo", false, false},
+		{"middle slash anchors", "docs/*.md", "docs/a.md", false, true},
+		{"middle slash does not match deeper", "docs/*.md", "x/docs/a.md", false, false},
+		{"double star prefix", "**/tmp", "a/b/tmp", true, true},
+		{"double star middle", "a/**/z", "a/b/c/z", false, true},
+		{"double star middle zero dirs", "a/**/z", "a/z", false, true},
+		{"double star suffix", "cache/**", "cache/x/y", false, true},
+		{"parent ignored, child negated", "secret/\n!secret/public.txt", "secret/public.txt", false, true},
+		{"trailing spaces trimmed", "*.bak  ", "a.bak", false, true},
+		{"escaped trailing space", `a\ `, "a ", false, true},
+	} {
+		m := New()
+		if err := m.Add(tc.content, "test"); err != nil {
+			t.Fatalf("%s: %s", tc.name, err)
+		}
+		if got := m.Ignored(tc.path, tc.isDir); got != tc.want {
+			t.Errorf("%s: %q ignores %q: got %v, want %v", tc.name, tc.content, tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestMatcherInvalidPattern(t *testing.T) {
+	err := New().Add("ok\n[z-a]\n", "test")
+	want := `Invalid ignore pattern "[z-a]" at test:2: error parsing regexp: invalid character class range: ` + "`z-a`"
+	if err == nil || err.Error() != want {
+		t.Errorf("got %v, want %q", err, want)
+	}
+}
@@ -8,7 +8,10 @@ import (
 )
 
 type Group struct {
-	ordered []string
+	Priority int
+	depth    int
+	position
+	ordered   []string
 	instances map[string]struct{}
 }
 
@@ -16,53 +19,116 @@ func (g *Group) List() []string {
 	return g.ordered
 }
 
+// Depth is the nesting level of a group: 0 for top level groups,
+// otherwise one more than the deepest parent.
+func (g *Group) Depth() int {
+	return g.depth
+}
+
 func (g *Group) Has(inst string) bool {
 	_, ok := g.instances[inst]
 	return ok
 }
 
+// UnmarshalYAML accepts either a plain list of members or a map
+// with "members" and an optional "priority".
 func (gr *Group) UnmarshalYAML(node *yaml.Node) error {
 	gr.instances = map[string]struct{}{}
 	lst := []string{}
-	node.Decode(&lst)
+	switch node.Kind {
+	case yaml.SequenceNode:
+		if err := node.Decode(&lst); err != nil {
+			return err
+		}
+	case yaml.MappingNode:
+		var full struct {
+			Priority int      `yaml:"priority"`
+			Members  []string `yaml:"members"`
+		}
+		if err := node.Decode(&full); err != nil {
+			return err
+		}
+		gr.Priority = full.Priority
+		lst = full.Members
+	default:
+		return ryaml.NodeError(node, "group must be a list of members or a map with members and priority")
+	}
 	gr.ordered = make([]string, 0, len(lst))
-	for _, name := range lst {
-		if _, ok := gr.instances[name]; ok {
-			return rerrors.NewErrDuplicate(name, "instance or host in group")
+	for _, pattern := range lst {
+		names, err := ExpandPattern(pattern)
+		if err != nil {
+			return ryaml.NodeError(node, "%s", err)
+		}
+		for _, name := range names {
+			if _, ok := gr.instances[name]; ok {
+				return ryaml.NodeError(node, "duplicate instance, host or group in group: %s", name)
+			}
+			gr.instances[name] = struct{}{}
+			gr.ordered = append(gr.ordered, name)
 		}
-		gr.instances[name] = struct{}{}
-		gr.ordered = append(gr.ordered, name)
 	}
 	return nil
 }
 
 type GroupsCollection map[string]*Group
 
-func NewGroupsCollection () GroupsCollection {
+func NewGroupsCollection() GroupsCollection {
 	return GroupsCollection{}
 }
 
-func ReadGroupsCollection(fileBaseNameOrDir string) GroupsCollection {
-	filenamesList := []string{}
-	maps := ryaml.ReadYamlRecursive(fileBaseNameOrDir, func(filename string) interface{} {
-		filenamesList = append(filenamesList, filename)
-		return NewGroupsCollection()
-	})
-
+// ReadGroupsCollection reads groups of every file or directory,
+// e. g. of every root.
+func ReadGroupsCollection(fileBaseNameOrDirs ...string) GroupsCollection {
 	c := NewGroupsCollection()
-	sources := map[string]string{}
-	for i, m := range maps {
-		for name, gr := range m.(GroupsCollection) {
-			if _, ok := c[name]; ok {
-				panic(rerrors.NewErrDuplicate(name, "group", sources[name], filenamesList[i]))
-			}
-			c[name] = gr
-			sources[name] = filenamesList[i]
+	errs := &rerrors.Collector{}
+	for _, fileBaseNameOrDir := range fileBaseNameOrDirs {
+		filenamesList := []string{}
+		maps := ryaml.CollectYamlRecursive(fileBaseNameOrDir, func(filename string) interface{} {
+			filenamesList = append(filenamesList, filename)
+			c := NewGroupsCollection()
+			return &c
+		}, errs)
+		for i, m := range maps {
+			errs.Catch(func() { c.Merge(*m.(*GroupsCollection), filenamesList[i]) })
 		}
 	}
+	errs.PanicIfAny()
 	return c
 }
 
+func (c *GroupsCollection) UnmarshalYAML(node *yaml.Node) error {
+	if *c == nil {
+		*c = NewGroupsCollection()
+	}
+	return ryaml.DecodeMapping(node, func(key, value *yaml.Node) error {
+		gr := &Group{position: position{line: key.Line, col: key.Column}, instances: map[string]struct{}{}}
+		(*c)[key.Value] = gr
+		if value.Tag == "!!null" {
+			return nil
+		}
+		return gr.UnmarshalYAML(value)
+	})
+}
+
+// Merge adds groups defined in a file to the collection.
+// It reports all duplicates at once.
+func (c GroupsCollection) Merge(other GroupsCollection, filename string) {
+	errs := &rerrors.Collector{}
+	for _, name := range sortedNames(other) {
+		gr := other[name]
+		if gr == nil {
+			gr = &Group{instances: map[string]struct{}{}}
+		}
+		gr.source = rerrors.Pos{File: filename, Line: gr.line, Col: gr.col}.String()
+		if existing, ok := c[name]; ok {
+			errs.Add(rerrors.NewErrDuplicate(name, "group", existing.source, gr.source))
+			continue
+		}
+		c[name] = gr
+	}
+	errs.PanicIfAny()
+}
+
 type ErrRepeatingGroup struct {
 	filename string
-}
\ No newline at end of file
+}
@@ -3,18 +3,39 @@ package inventory
 import (
 	"fmt"
 	"golden/pkg/rerrors"
+	"golden/pkg/rtemplate"
 	"golden/pkg/ryaml"
 	"os/user"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
 )
 
+// Host describes how to reach a host. All ssh_* fields can be templates
+// over host level variables, see resolver.ResolveHost. Every host of a pattern
+// gets the same fields, so templates depending on the expanded host name,
+// e. g. ssh_hostname, must use {{ ._host_ }}.
 type Host struct {
-	SshConfigHost string `yaml:"ssh_config_host"`
-	SshHostname   string `yaml:"ssh_hostname"`
-	SshUser       string `yaml:"ssh_user"`
+	SshConfigHost     string            `yaml:"ssh_config_host"`
+	SshHostname       string            `yaml:"ssh_hostname"`
+	SshUser           string            `yaml:"ssh_user"`
+	SshPort           string            `yaml:"ssh_port"`
+	SshIdentityFile   string            `yaml:"ssh_identity_file"`
+	SshJumpHosts      []string          `yaml:"ssh_jump_hosts"`
+	SshOptions        map[string]string `yaml:"ssh_options"`
+	SshConnectTimeout string            `yaml:"ssh_connect_timeout"`
+
+	// BecomeUser makes golden deploy instances of a host as another user
+	// after logging in. BecomeMethod is either sudo (default) or su.
+	BecomeUser   string `yaml:"become_user"`
+	BecomeMethod string `yaml:"become_method"`
+
+	position
 }
 
 func (h *Host) IsLocalHost() bool {
-	if h.SshConfigHost != "" {
+	if h.SshConfigHost != "" || h.SshPort != "" || len(h.SshJumpHosts) > 0 {
 		return false
 	}
 	if h.SshHostname == "localhost" || h.SshHostname == "127.0.0.1" || h.SshHostname == "" {
@@ -61,9 +82,36 @@ func (h *Host) GetSshConnStr() string {
 	if h.SshConfigHost != "" {
 		return h.SshConfigHost
 	}
+	if h.SshUser == "" {
+		return h.SshHostname
+	}
 	return fmt.Sprintf("%s@%s", h.SshUser, h.SshHostname)
 }
 
+// GetSshArgs returns options for ssh and scp derived from ssh_* fields.
+// Options are passed as -o to be understood by both.
+func (h *Host) GetSshArgs() []string {
+	args := []string{}
+	option := func(k, v string) {
+		if v != "" {
+			args = append(args, "-o", k+"="+v)
+		}
+	}
+	option("Port", h.SshPort)
+	option("IdentityFile", h.SshIdentityFile)
+	option("ProxyJump", strings.Join(h.SshJumpHosts, ","))
+	option("ConnectTimeout", h.SshConnectTimeout)
+	keys := make([]string, 0, len(h.SshOptions))
+	for k := range h.SshOptions {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		option(k, h.SshOptions[k])
+	}
+	return args
+}
+
 func (h *Host) String() string {
 	if h.IsThisUser() {
 		return "[local]"
@@ -76,23 +124,132 @@ func (h *Host) String() string {
 
 type HostsCollection map[string]*Host
 
-func ReadHosts(fileBaseNameOrDir string) HostsCollection {
-	filenamesList := []string{}
-	maps := ryaml.ReadYamlRecursive(fileBaseNameOrDir, func(filename string) interface{} {
-		filenamesList = append(filenamesList, filename)
-		return HostsCollection{}
-	})
+// ReadHosts reads hosts of every file or directory, e. g. of every root.
+func ReadHosts(fileBaseNameOrDirs ...string) HostsCollection {
 	merged := HostsCollection{}
-	sources := map[string]string{}
-	for i, m := range maps {
-		inv := m.(HostsCollection)
-		for k, v := range inv {
-			if _, ok := merged[k]; ok {
-				panic(rerrors.NewErrDuplicate(k, "host definition", sources[k], filenamesList[i]))
-			}
-			merged[k] = v
-			sources[k] = filenamesList[i]
+	errs := &rerrors.Collector{}
+	for _, fileBaseNameOrDir := range fileBaseNameOrDirs {
+		filenamesList := []string{}
+		maps := ryaml.CollectYamlRecursive(fileBaseNameOrDir, func(filename string) interface{} {
+			filenamesList = append(filenamesList, filename)
+			return &HostsCollection{}
+		}, errs)
+		for i, m := range maps {
+			errs.Catch(func() { merged.Merge(*m.(*HostsCollection), filenamesList[i]) })
 		}
 	}
+	errs.PanicIfAny()
 	return merged
 }
+
+func (c *HostsCollection) UnmarshalYAML(node *yaml.Node) error {
+	if *c == nil {
+		*c = HostsCollection{}
+	}
+	return ryaml.DecodeMapping(node, func(key, value *yaml.Node) error {
+		h := &Host{position: position{line: key.Line, col: key.Column}}
+		(*c)[key.Value] = h
+		return value.Decode(h)
+	})
+}
+
+// Merge adds hosts defined in a file to the collection expanding host patterns.
+// It reports all invalid patterns and duplicates at once.
+func (c HostsCollection) Merge(other HostsCollection, filename string) {
+	errs := &rerrors.Collector{}
+	for _, pattern := range sortedNames(other) {
+		v := other[pattern]
+		if v == nil {
+			v = &Host{}
+		}
+		source := rerrors.Pos{File: filename, Line: v.line, Col: v.col}.String()
+		names, err := ExpandPattern(pattern)
+		if err != nil {
+			errs.Add(rerrors.NewErrStringf("%s: host %s: %s", source, pattern, err))
+			continue
+		}
+		for _, k := range names {
+			if existing, ok := c[k]; ok {
+				errs.Add(rerrors.NewErrDuplicate(k, "host definition", existing.source, source))
+				continue
+			}
+			c[k] = v.expand(source)
+		}
+	}
+	errs.PanicIfAny()
+}
+
+// Override replaces hosts of the collection with hosts of another one
+// and adds new ones, e. g. hosts of an environment.
+func (c HostsCollection) Override(other HostsCollection) {
+	for k, v := range other {
+		c[k] = v
+	}
+}
+
+// expand returns a copy of a host definition for one host of its pattern.
+func (h *Host) expand(source string) *Host {
+	expanded := *h
+	expanded.source = source
+	return &expanded
+}
+
+func (h *Host) templatedFields() []*string {
+	fields := []*string{
+		&h.SshConfigHost, &h.SshHostname, &h.SshUser,
+		&h.SshPort, &h.SshIdentityFile, &h.SshConnectTimeout,
+		&h.BecomeUser, &h.BecomeMethod,
+	}
+	for i := range h.SshJumpHosts {
+		fields = append(fields, &h.SshJumpHosts[i])
+	}
+	return fields
+}
+
+func (h *Host) IsTemplated() bool {
+	for _, field := range h.templatedFields() {
+		if strings.Contains(*field, "{{") {
+			return true
+		}
+	}
+	for _, v := range h.SshOptions {
+		if strings.Contains(v, "{{") {
+			return true
+		}
+	}
+	return false
+}
+
+// ExecTemplates returns a copy of a host definition with ssh_* fields
+// executed as templates against dot.
+func (h *Host) ExecTemplates(name string, dot interface{}) *Host {
+	executed := *h
+	executed.SshJumpHosts = append([]string{}, h.SshJumpHosts...)
+	executed.SshOptions = make(map[string]string, len(h.SshOptions))
+	for k, v := range h.SshOptions {
+		executed.SshOptions[k] = v
+	}
+
+	exec := func(field *string) {
+		if !strings.Contains(*field, "{{") {
+			return
+		}
+		ctx := fmt.Sprintf("%s: host %s", h.source, name)
+		t, err := rtemplate.New(ctx).Option("missingkey=error").Parse(*field)
+		if err != nil {
+			panic(rtemplate.NewErrParse(ctx, err))
+		}
+		*field, err = rtemplate.ExecToString(t, dot)
+		if err != nil {
+			panic(rtemplate.NewErrExec(h.source, "host "+name, err))
+		}
+	}
+	for _, field := range executed.templatedFields() {
+		exec(field)
+	}
+	for k, v := range executed.SshOptions {
+		exec(&v)
+		executed.SshOptions[k] = v
+	}
+	return &executed
+}
@@ -1,15 +1,85 @@
 package inventory
 
 import (
+	"fmt"
 	"golden/pkg/rerrors"
+	"golden/pkg/rtemplate"
 	"golden/pkg/ryaml"
+	"strings"
+
+	"gopkg.in/yaml.v3"
 )
 
+const defaultInstanceNameTemplate = "{{ ._app_ }}-{{ ._host_ }}"
+
 type Instance struct {
 	Name          string `yaml:"name"`
 	Host          string `yaml:"host"`
 	App           string `yaml:"app"`
 	InstallPrefix string `yaml:"install_prefix"`
+
+	// BecomeUser and BecomeMethod override those of the host.
+	BecomeUser   string `yaml:"become_user"`
+	BecomeMethod string `yaml:"become_method"`
+
+	// ForEachHost makes an instance definition a template: one instance
+	// is generated per every listed host, host pattern or host of a group.
+	// An empty list is an error rather than a regular instance.
+	ForEachHost []string `yaml:"for_each_host"`
+
+	nameTemplate string
+	position
+}
+
+// GetBecome returns the user to become and the method for an instance on a host.
+// An empty user means no privilege escalation.
+func (inst *Instance) GetBecome(h *Host) (user string, method string) {
+	if inst.BecomeUser != "" {
+		return inst.BecomeUser, inst.BecomeMethod
+	}
+	return h.BecomeUser, h.BecomeMethod
+}
+
+func (inst *Instance) IsTemplate() bool {
+	return inst.ForEachHost != nil
+}
+
+// generate stamps out an instance of a template for a host.
+// Name and install_prefix of a template may use ._app_, ._host_ and ._template_,
+// install_prefix may use ._instance_ as well.
+func (inst *Instance) generate(host string) *Instance {
+	dot := map[string]interface{}{
+		"_app_":      inst.App,
+		"_host_":     host,
+		"_template_": inst.Name,
+	}
+	name := inst.execField("name", inst.nameTemplate, dot)
+	dot["_instance_"] = name
+	return &Instance{
+		Name:          name,
+		Host:          host,
+		App:           inst.App,
+		InstallPrefix: inst.execField("install_prefix", inst.InstallPrefix, dot),
+		BecomeUser:    inst.BecomeUser,
+		BecomeMethod:  inst.BecomeMethod,
+		position:      position{source: fmt.Sprintf("%s (template %s)", inst.source, inst.Name)},
+	}
+}
+
+func (inst *Instance) execField(field, value string, dot map[string]interface{}) string {
+	if !strings.Contains(value, "{{") {
+		return value
+	}
+	ctx := fmt.Sprintf("%s: instance template %s: %s", inst.source, inst.Name, field)
+	t, err := rtemplate.New(ctx).Option("missingkey=error").Parse(value)
+	if err != nil {
+		panic(rtemplate.NewErrParse(ctx, err))
+	}
+	out, err := rtemplate.ExecToString(t, dot)
+	if err != nil {
+		panic(rtemplate.NewErrExec(inst.source, "instance template "+inst.Name+": "+field, err))
+	}
+	return out
 }
 
 type InstancesCollection map[string]*Instance
@@ -18,24 +88,65 @@ func NewInstancesCollection() InstancesCollection {
 	return InstancesCollection{}
 }
 
-func ReadInstancesCollection(fileBaseNameOrDir string) InstancesCollection {
-	filenamesList := []string{}
-	maps := ryaml.ReadYamlRecursive(fileBaseNameOrDir, func(filename string) interface{} {
-		filenamesList = append(filenamesList, filename)
-		return NewInstancesCollection()
-	})
-
+// ReadInstancesCollection reads instances of every file or directory,
+// e. g. of every root.
+func ReadInstancesCollection(fileBaseNameOrDirs ...string) InstancesCollection {
 	c := NewInstancesCollection()
-	sources := map[string]string{}
-	for i, m := range maps {
-		for name, inst := range m.(InstancesCollection) {
-			inst.Name = name
-			if _, ok := c[name]; ok {
-				panic(rerrors.NewErrDuplicate(inst.Name, "instance", sources[name], filenamesList[i]))
-			}
-			c[name] = inst
-			sources[name] = filenamesList[i]
+	errs := &rerrors.Collector{}
+	for _, fileBaseNameOrDir := range fileBaseNameOrDirs {
+		filenamesList := []string{}
+		maps := ryaml.CollectYamlRecursive(fileBaseNameOrDir, func(filename string) interface{} {
+			filenamesList = append(filenamesList, filename)
+			c := NewInstancesCollection()
+			return &c
+		}, errs)
+		for i, m := range maps {
+			errs.Catch(func() { c.Merge(*m.(*InstancesCollection), filenamesList[i]) })
 		}
 	}
+	errs.PanicIfAny()
 	return c
 }
+
+func (c *InstancesCollection) UnmarshalYAML(node *yaml.Node) error {
+	if *c == nil {
+		*c = NewInstancesCollection()
+	}
+	return ryaml.DecodeMapping(node, func(key, value *yaml.Node) error {
+		inst := &Instance{position: position{line: key.Line, col: key.Column}}
+		(*c)[key.Value] = inst
+		return value.Decode(inst)
+	})
+}
+
+// Override replaces instances of the collection with instances of another one
+// and adds new ones, e. g. instances of an environment.
+func (c InstancesCollection) Override(other InstancesCollection) {
+	for k, v := range other {
+		c[k] = v
+	}
+}
+
+// Merge adds instances and instance templates defined in a file to the collection.
+// It reports all duplicates at once.
+func (c InstancesCollection) Merge(other InstancesCollection, filename string) {
+	errs := &rerrors.Collector{}
+	for _, name := range sortedNames(other) {
+		inst := other[name]
+		if inst == nil {
+			inst = &Instance{}
+		}
+		inst.nameTemplate = inst.Name
+		if inst.nameTemplate == "" {
+			inst.nameTemplate = defaultInstanceNameTemplate
+		}
+		inst.Name = name
+		inst.source = rerrors.Pos{File: filename, Line: inst.line, Col: inst.col}.String()
+		if existing, ok := c[name]; ok {
+			errs.Add(rerrors.NewErrDuplicate(inst.Name, "instance", existing.source, inst.source))
+			continue
+		}
+		c[name] = inst
+	}
+	errs.PanicIfAny()
+}
@@ -0,0 +1,21 @@
+package inventory
+
+import (
+	"golden/pkg/rerrors/rerrorstest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadInstancesRejectsDuplicateKeys(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "instances.yml")
+	content := "a:\n  app: x\n  host: h\na:\n  app: y\n  host: h\n"
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	want := file + `:4:1: mapping key "a" already defined at line 1`
+	if err := rerrorstest.Message(func() { ReadInstancesCollection(file) }); !strings.Contains(err, want) {
+		t.Errorf("got %q, want %q", err, want)
+	}
+}
@@ -3,7 +3,11 @@ package inventory
 import (
 	"golden/pkg/manifest"
 	"golden/pkg/rerrors"
+	"golden/pkg/root"
+	"golden/pkg/varmap"
 	"path/filepath"
+	"sort"
+	"strings"
 )
 
 type Inventory struct {
@@ -12,41 +16,33 @@ type Inventory struct {
 	hosts          HostsCollection
 	hostInstances  map[string][]*Instance
 	instanceGroups map[string][]string
+	hostGroups     map[string][]string
+	pluginVars     map[string]map[string]varmap.VarMap
 }
 
-func (inv *Inventory) GetInstancesForManifest(names manifest.Manifest) []*Instance {
-	outMap := map[string]struct{}{}
-	out := make([]*Instance, 0, len(names))
-
-	for _, name := range names {
-		if inst, ok := inv.instances[name]; ok {
-			if _, present := outMap[name]; present {
-				continue
-			}
-			outMap[name] = struct{}{}
-			out = append(out, inst)
-			continue
-		}
-		if _, ok := inv.hosts[name]; ok {
-			if insts, ok := inv.hostInstances[name]; ok {
-				for _, inst := range insts {
-					if _, ok := outMap[inst.Name]; !ok {
-						outMap[inst.Name] = struct{}{}
-						out = append(out, inst)
-					}
+// GetInstancesForManifest returns a union of instances matching every
+// selector expression of a manifest. See Selector for the syntax.
+// Errors of selectors are reported at their positions within the manifest.
+// It reports all errors at once.
+func (inv *Inventory) GetInstancesForManifest(m *manifest.Manifest) []*Instance {
+	errs := &rerrors.Collector{}
+	out := make([]*Instance, 0, len(m.Selectors))
+	for i, expr := range m.Selectors {
+		pos, hasPos := m.SelectorPos(i)
+		errs.Catch(func() {
+			defer func() {
+				recovered := recover()
+				if nice, ok := recovered.(rerrors.NiceError); ok && hasPos {
+					recovered = rerrors.NewErrAt(pos, "%s", nice.NiceError())
 				}
-			}
-		}
-		if group, ok := inv.groups[name]; ok {
-			for _, instName := range group.List() {
-				if _, ok := outMap[instName]; !ok {
-					outMap[instName] = struct{}{}
-					out = append(out, inv.instances[instName])
+				if recovered != nil {
+					panic(recovered)
 				}
-			}
-		}
+			}()
+			out = unionInstances(out, inv.Select(expr))
+		})
 	}
-
+	errs.PanicIfAny()
 	return out
 }
 
@@ -54,10 +50,54 @@ func (inv *Inventory) GetHost(host string) *Host {
 	return inv.hosts[host]
 }
 
+// GetGroups returns groups of an instance ordered by precedence:
+// by priority ascending, then parents before nested groups, then by name.
+// Vars of later groups win.
 func (inv *Inventory) GetGroups(instance string) []string {
 	return inv.instanceGroups[instance]
 }
 
+// GetHostGroups returns groups a host is a member of, directly or via
+// nested groups, ordered the same way as GetGroups.
+func (inv *Inventory) GetHostGroups(host string) []string {
+	return inv.hostGroups[host]
+}
+
+// GetGroupHosts returns sorted hosts which are members of a group
+// or have instances in it, nil for an unknown group.
+func (inv *Inventory) GetGroupHosts(group string) []string {
+	gr, ok := inv.groups[group]
+	if !ok {
+		return nil
+	}
+	seen := map[string]struct{}{}
+	for host, groups := range inv.hostGroups {
+		for _, gr := range groups {
+			if gr == group {
+				seen[host] = struct{}{}
+			}
+		}
+	}
+	for _, inst := range gr.List() {
+		seen[inv.instances[inst].Host] = struct{}{}
+	}
+	hosts := make([]string, 0, len(seen))
+	for host := range seen {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+// OverrideHost replaces a host definition, e. g. with a resolved one.
+func (inv *Inventory) OverrideHost(name string, h *Host) {
+	inv.hosts[name] = h
+}
+
+func (inv *Inventory) GetGroup(group string) *Group {
+	return inv.groups[group]
+}
+
 func (inv *Inventory) GetAllInstances() InstancesCollection {
 	return inv.instances
 }
@@ -69,12 +109,14 @@ func New() *Inventory {
 		hosts:          map[string]*Host{},
 		hostInstances:  map[string][]*Instance{},
 		instanceGroups: map[string][]string{},
+		hostGroups:     map[string][]string{},
+		pluginVars:     map[string]map[string]varmap.VarMap{},
 	}
 }
 
 func (inv *Inventory) SetHostsToLocalhost() {
 	for _, h := range inv.hosts {
-		*h = Host{}
+		*h = Host{position: h.position}
 	}
 }
 
@@ -84,16 +126,44 @@ func (inv *Inventory) OverrideInstallPrefix(overrides map[string]string) {
 	}
 }
 
-func ReadInventory(rootDir string) *Inventory {
+// EnvsDir holds environments. An environment may override hosts, instances
+// and variables of any kind, e. g. envs/staging/hosts.yml replaces definitions
+// of hosts with the same names in hosts.yml.
+const EnvsDir = "envs"
+
+// ReadInventory reads the inventory of all roots with an environment
+// overlaid if env is not empty. Hosts, instances and groups of all roots
+// must have unique names. It reports all errors found at once.
+func ReadInventory(rs root.Roots, env string) *Inventory {
 	inv := New()
+	errs := &rerrors.Collector{}
+
+	errs.Catch(func() { inv.instances = ReadInstancesCollection(rs.Paths("instances")...) })
+	errs.Catch(func() { inv.hosts = ReadHosts(rs.Paths("hosts")...) })
+	if env != "" {
+		envDir := filepath.Join(EnvsDir, env)
+		if rs.Find(envDir) == "" {
+			errs.Add(rerrors.NewErrStringf("environment %s does not exist: no directory %s in %s", env, envDir, strings.Join(rs, ", ")))
+		} else {
+			errs.Catch(func() {
+				inv.instances.Override(ReadInstancesCollection(rs.Paths(filepath.Join(envDir, "instances"))...))
+			})
+			errs.Catch(func() { inv.hosts.Override(ReadHosts(rs.Paths(filepath.Join(envDir, "hosts"))...)) })
+		}
+	}
+	errs.Catch(func() { inv.groups = ReadGroupsCollection(rs.Paths("groups")...) })
+	for _, dir := range rs.Paths("inventory.d") {
+		errs.Catch(func() { inv.readPlugins(dir) })
+	}
+	errs.PanicIfAny()
 
-	inv.instances = ReadInstancesCollection(filepath.Join(rootDir, "instances"))
-	inv.hosts = ReadHosts(filepath.Join(rootDir, "hosts"))
-	inv.groups = ReadGroupsCollection(filepath.Join(rootDir, "groups"))
-	inv.MustHaveUniqueNames()
+	expandedGroups := inv.expandNestedGroups()
+	errs.Catch(func() { inv.generateTemplatedInstances(expandedGroups) })
+	errs.Catch(inv.MustHaveUniqueNames)
 
 	// Forming inv.hostInstances
-	for _, inst := range inv.instances {
+	for _, name := range sortedNames(inv.instances) {
+		inst := inv.instances[name]
 		host := inst.Host
 		if insts, ok := inv.hostInstances[host]; ok {
 			inv.hostInstances[host] = append(insts, inst)
@@ -103,11 +173,11 @@ func ReadInventory(rootDir string) *Inventory {
 	}
 
 	instanceGroupsAsMap := map[string]map[string]struct{}{}
-	hostGroups := map[string][]string{}
+	hostGroups := inv.hostGroups
 
 	// Forming hostGroups and direct instanceGroups
-	for grName, group := range inv.groups {
-		for name := range group.instances {
+	for _, grName := range sortedNames(inv.groups) {
+		for _, name := range expandedGroups[grName] {
 
 			if _, isHost := inv.hosts[name]; isHost {
 				if groups, ok := hostGroups[name]; ok {
@@ -126,9 +196,14 @@ func ReadInventory(rootDir string) *Inventory {
 				}
 				continue
 			}
-			panic(rerrors.NewErrStringf("%s is not a instance/host, but specified in group %s", name, grName))
+			errs.Add(rerrors.NewErrStringf("%s is not a instance/host/group, but specified in group %s (%s)", name, grName, inv.groups[grName].source))
 		}
 	}
+	errs.PanicIfAny()
+
+	for _, groups := range hostGroups {
+		inv.sortGroupsByPrecedence(groups)
+	}
 
 	// Group inheritance for instances via hosts
 	for host, insts := range inv.hostInstances {
@@ -156,39 +231,224 @@ func ReadInventory(rootDir string) *Inventory {
 		gr.instances = map[string]struct{}{}
 		gr.ordered = []string{}
 	}
-	for inst, groups := range instanceGroupsAsMap {
+	for _, inst := range sortedNames(instanceGroupsAsMap) {
 		inv.instanceGroups[inst] = []string{}
-		for grName := range groups {
+		for _, grName := range sortedNames(instanceGroupsAsMap[inst]) {
 			inv.instanceGroups[inst] = append(inv.instanceGroups[inst], grName)
 			inv.groups[grName].instances[inst] = struct{}{}
 			inv.groups[grName].ordered = append(inv.groups[grName].ordered, inst)
 		}
+		inv.sortGroupsByPrecedence(inv.instanceGroups[inst])
 	}
 
 	return inv
 }
 
-func (inv *Inventory) MustHaveUniqueNames() {
+// expandNestedGroups returns members of every group with nested groups
+// replaced by their members recursively. It also sets depth of every group.
+func (inv *Inventory) expandNestedGroups() map[string][]string {
+	expanded := map[string][]string{}
+	parents := map[string][]string{}
+	inProgress := map[string]bool{}
+
+	var expand func(grName string, chain []string) []string
+	expand = func(grName string, chain []string) []string {
+		if members, ok := expanded[grName]; ok {
+			return members
+		}
+		chain = append(chain, grName)
+		if inProgress[grName] {
+			panic(rerrors.NewErrStringf("cyclic nesting of groups: %s", strings.Join(chain, " -> ")))
+		}
+		inProgress[grName] = true
+
+		members := []string{}
+		seen := map[string]struct{}{}
+		for _, name := range inv.groups[grName].ordered {
+			names := []string{name}
+			if _, isGroup := inv.groups[name]; isGroup {
+				parents[name] = append(parents[name], grName)
+				names = expand(name, chain)
+			}
+			for _, n := range names {
+				if _, ok := seen[n]; ok {
+					continue
+				}
+				seen[n] = struct{}{}
+				members = append(members, n)
+			}
+		}
+
+		delete(inProgress, grName)
+		expanded[grName] = members
+		return members
+	}
+
+	for _, grName := range sortedNames(inv.groups) {
+		expand(grName, nil)
+	}
+
+	var depth func(grName string) int
+	depth = func(grName string) int {
+		gr := inv.groups[grName]
+		if gr.depth >= 0 {
+			return gr.depth
+		}
+		gr.depth = 0
+		for _, parent := range parents[grName] {
+			if d := depth(parent) + 1; d > gr.depth {
+				gr.depth = d
+			}
+		}
+		return gr.depth
+	}
+	for _, gr := range inv.groups {
+		gr.depth = -1
+	}
+	for grName := range inv.groups {
+		depth(grName)
+	}
+
+	return expanded
+}
+
+// generateTemplatedInstances replaces instance templates with instances
+// generated for each of their hosts. It reports all errors at once.
+func (inv *Inventory) generateTemplatedInstances(expandedGroups map[string][]string) {
+	errs := &rerrors.Collector{}
+	templates := []*Instance{}
+	for name, inst := range inv.instances {
+		if inst.IsTemplate() {
+			templates = append(templates, inst)
+			delete(inv.instances, name)
+		}
+	}
+	sort.Slice(templates, func(i, j int) bool { return templates[i].Name < templates[j].Name })
+
+	for _, tmpl := range templates {
+		hosts := []string{}
+		if !errs.Catch(func() { hosts = inv.templateHosts(tmpl, expandedGroups) }) {
+			continue
+		}
+		for _, host := range hosts {
+			var inst *Instance
+			if !errs.Catch(func() { inst = tmpl.generate(host) }) {
+				continue
+			}
+			if existing, ok := inv.instances[inst.Name]; ok {
+				errs.Add(rerrors.NewErrDuplicate(inst.Name, "instance", existing.source, inst.source))
+				continue
+			}
+			inv.instances[inst.Name] = inst
+		}
+	}
+	errs.PanicIfAny()
+}
 
-	names := map[string]struct{}{}
+func (inv *Inventory) templateHosts(tmpl *Instance, expandedGroups map[string][]string) []string {
+	errs := &rerrors.Collector{}
+	hosts := []string{}
+	seen := map[string]struct{}{}
+	add := func(host string) {
+		if _, ok := seen[host]; !ok {
+			seen[host] = struct{}{}
+			hosts = append(hosts, host)
+		}
+	}
+	if len(tmpl.ForEachHost) == 0 {
+		panic(rerrors.NewErrStringf("%s: instance template %s: for_each_host is empty", tmpl.source, tmpl.Name))
+	}
+	for _, pattern := range tmpl.ForEachHost {
+		names, err := ExpandPattern(pattern)
+		if err != nil {
+			errs.Add(rerrors.NewErrStringf("%s: instance template %s: %s", tmpl.source, tmpl.Name, err))
+			continue
+		}
+		for _, name := range names {
+			if _, isHost := inv.hosts[name]; isHost {
+				add(name)
+				continue
+			}
+			if _, isGroup := inv.groups[name]; isGroup {
+				for _, member := range expandedGroups[name] {
+					if _, isHost := inv.hosts[member]; isHost {
+						add(member)
+						continue
+					}
+					errs.Add(rerrors.NewErrStringf(
+						"%s is not a host, but a member of group %s specified in for_each_host of instance template %s",
+						member, name, tmpl.Name,
+					))
+				}
+				continue
+			}
+			errs.Add(rerrors.NewErrStringf(
+				"%s is not a host/group, but specified in for_each_host of instance template %s",
+				name, tmpl.Name,
+			))
+		}
+	}
+	errs.PanicIfAny()
+	return hosts
+}
 
-	for n, _ := range inv.instances {
-		if _, ok := names[n]; ok {
-			panic(rerrors.NewErrDuplicate(n, "instance/host/group"))
+// sortGroupsByPrecedence orders groups by priority, then parents before
+// their nested groups, then by name.
+func (inv *Inventory) sortGroupsByPrecedence(groups []string) {
+	sort.Slice(groups, func(i, j int) bool {
+		gi, gj := inv.groups[groups[i]], inv.groups[groups[j]]
+		if gi.Priority != gj.Priority {
+			return gi.Priority < gj.Priority
+		}
+		if gi.depth != gj.depth {
+			return gi.depth < gj.depth
 		}
-		names[n] = struct{}{}
+		return groups[i] < groups[j]
+	})
+}
+
+// MustHaveUniqueNames panics with all names shared by instances, hosts
+// and groups.
+func (inv *Inventory) MustHaveUniqueNames() {
+	errs := &rerrors.Collector{}
+	names := map[string]string{}
+
+	for _, n := range sortedNames(inv.instances) {
+		names[n] = inv.instances[n].source
 	}
-	for n, _ := range inv.hosts {
-		if _, ok := names[n]; ok {
-			panic(rerrors.NewErrDuplicate(n, "instance/host/group"))
+	for _, n := range sortedNames(inv.hosts) {
+		if src, ok := names[n]; ok {
+			errs.Add(rerrors.NewErrDuplicate(n, "instance/host/group", src, inv.hosts[n].source))
+			continue
 		}
-		names[n] = struct{}{}
+		names[n] = inv.hosts[n].source
 	}
-	for n, _ := range inv.groups {
-		if _, ok := names[n]; ok {
-			panic(rerrors.NewErrDuplicate(n, "instance/host/group"))
+	for _, n := range sortedNames(inv.groups) {
+		if src, ok := names[n]; ok {
+			errs.Add(rerrors.NewErrDuplicate(n, "instance/host/group", src, inv.groups[n].source))
+			continue
 		}
-		names[n] = struct{}{}
+		names[n] = inv.groups[n].source
 	}
+	errs.PanicIfAny()
+}
 
+// sortedNames returns names of a collection in order, so that errors
+// are reported and collections are built in the same order every run.
+// position is where a host, an instance or a group is defined. Line and col
+// are recorded by UnmarshalYAML of collections, source is set once the file
+// is known to the file with them, e. g. hosts.yml:3:1.
+type position struct {
+	source string
+	line   int
+	col    int
+}
+
+func sortedNames[T any](c map[string]T) []string {
+	names := make([]string, 0, len(c))
+	for name := range c {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
 }
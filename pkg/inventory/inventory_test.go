@@ -0,0 +1,122 @@
+package inventory
+
+import (
+	"golden/pkg/manifest"
+	"golden/pkg/rerrors/rerrorstest"
+	"golden/pkg/root"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadInventoryForEachHostErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name, groups, instances, want string
+	}{
+		{
+			"empty list",
+			"",
+			"app:\n  app: app\n  for_each_host: []\n",
+			"instance template app: for_each_host is empty",
+		},
+		{
+			"instance in a group",
+			"mixed: [h1, db]\n",
+			"db:\n  app: db\n  host: h1\napp:\n  app: app\n  for_each_host: [mixed]\n",
+			"db is not a host, but a member of group mixed specified in for_each_host of instance template app",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rs := writeRoot(t, map[string]string{
+				"hosts.yml":     "h1:\n  ssh_hostname: localhost\n",
+				"groups.yml":    tc.groups,
+				"instances.yml": tc.instances,
+			})
+			if err := rerrorstest.Message(func() { ReadInventory(rs, "") }); !strings.Contains(err, tc.want) {
+				t.Errorf("got %q, want %q", err, tc.want)
+			}
+		})
+	}
+}
+
+// writeRoot writes files of a root to a temporary directory.
+func writeRoot(t *testing.T, files map[string]string) root.Roots {
+	dir := t.TempDir()
+	for file, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, file), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root.Roots{dir}
+}
+
+func TestReadInventoryNestedGroups(t *testing.T) {
+	inv := ReadInventory(writeRoot(t, map[string]string{
+		"hosts.yml":     "h1: {}\nh2: {}\n",
+		"instances.yml": "web1: {app: nginx, host: h1}\nweb2: {app: nginx, host: h2}\ndb: {app: postgres, host: h2}\n",
+		"groups.yml":    "all: [web, db-group]\nweb: [web1, frontends]\nfrontends: [web2]\ndb-group: [h2]\n",
+	}), "")
+	for _, tc := range []struct {
+		inst string
+		want []string
+	}{
+		{"web1", []string{"all", "web"}},
+		{"web2", []string{"all", "db-group", "web", "frontends"}},
+		{"db", []string{"all", "db-group"}},
+	} {
+		if got := inv.GetGroups(tc.inst); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("groups of %s = %v, want %v", tc.inst, got, tc.want)
+		}
+	}
+	for group, want := range map[string]int{"all": 0, "web": 1, "db-group": 1, "frontends": 2} {
+		if got := inv.GetGroup(group).Depth(); got != want {
+			t.Errorf("depth of %s = %d, want %d", group, got, want)
+		}
+	}
+	if got, want := inv.GetGroup("all").List(), []string{"db", "web1", "web2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("instances of all = %v, want %v", got, want)
+	}
+	if got, want := inv.GetHostGroups("h2"), []string{"all", "db-group"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("groups of h2 = %v, want %v", got, want)
+	}
+	if got, want := inv.GetGroupHosts("web"), []string{"h1", "h2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("hosts of web = %v, want %v", got, want)
+	}
+}
+
+func TestReadInventoryCyclicGroups(t *testing.T) {
+	for groups, want := range map[string]string{
+		"a: [a]\n":                         "cyclic nesting of groups: a -> a",
+		"c: [a]\nb: [c]\na: [b]\n":         "cyclic nesting of groups: a -> b -> c -> a",
+		"z: [b]\nb: [c, h1]\nc: [b]\n":     "cyclic nesting of groups: b -> c -> b",
+		"x: [y]\ny: [x]\nv: [w]\nw: [v]\n": "cyclic nesting of groups: v -> w -> v",
+	} {
+		rs := writeRoot(t, map[string]string{"hosts.yml": "h1: {}\n", "groups.yml": groups})
+		// the same cycle is reported every run
+		for i := 0; i < 5; i++ {
+			if err := rerrorstest.Message(func() { ReadInventory(rs, "") }); err != want {
+				t.Errorf("%q: got %q, want %q", groups, err, want)
+				break
+			}
+		}
+	}
+}
+
+func TestGetInstancesForManifestReportsPositions(t *testing.T) {
+	rs := writeRoot(t, map[string]string{
+		"hosts.yml":     "h1: {}\n",
+		"instances.yml": "web1: {app: nginx, host: h1}\n",
+		"manifests.yml": "bad:\n  - web1\n  - \"web1 &\"\n  - host(nope)\n",
+	})
+	inv := ReadInventory(rs, "")
+	manifests := manifest.ReadManifestsCollection(rs.Paths("manifests")...)
+	file := rs.Paths("manifests.yml")[0]
+	want := "2 errors:\n" +
+		"- " + file + ":3:5: Invalid selector: expected a name\n  \tweb1 &\n  \t      ^\n" +
+		"- " + file + ":4:5: Selector refers to unknown host: nope"
+	if err := rerrorstest.Message(func() { inv.GetInstancesForManifest(manifests["bad"]) }); err != want {
+		t.Errorf("got %q, want %q", err, want)
+	}
+}
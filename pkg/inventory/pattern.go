@@ -0,0 +1,117 @@
+package inventory
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// maxExpandedNames caps the number of names a pattern expands to,
+// so that a typo such as web[1:9999999] is reported rather than
+// exhausting memory.
+const maxExpandedNames = 10000
+
+// ExpandPattern expands ranges and alternatives within a name:
+//
+//	web[01:03]  -> web01, web02, web03
+//	rack[a:c]   -> racka, rackb, rackc
+//	db-{a,b}    -> db-a, db-b
+//
+// Several patterns within one name are expanded to all combinations
+// in the order they are written. A pattern must not expand to more than
+// maxExpandedNames names.
+func ExpandPattern(name string) ([]string, error) {
+	start := strings.IndexAny(name, "[{")
+	if start == -1 {
+		if strings.ContainsAny(name, "]}") {
+			return nil, fmt.Errorf("unbalanced brackets in %q", name)
+		}
+		return []string{name}, nil
+	}
+
+	closing := "]"
+	if name[start] == '{' {
+		closing = "}"
+	}
+	length := strings.Index(name[start:], closing)
+	if length == -1 {
+		return nil, fmt.Errorf("unbalanced brackets in %q", name)
+	}
+	end := start + length
+	body := name[start+1 : end]
+	if strings.ContainsAny(body, "[{") {
+		return nil, fmt.Errorf("nested patterns are not supported in %q", name)
+	}
+
+	var variants []string
+	var err error
+	if closing == "]" {
+		variants, err = expandRange(body)
+		if err != nil {
+			return nil, fmt.Errorf("%s in %q", err, name)
+		}
+	} else {
+		variants = strings.Split(body, ",")
+	}
+
+	rest, err := ExpandPattern(name[end+1:])
+	if err != nil {
+		return nil, err
+	}
+	if len(variants)*len(rest) > maxExpandedNames {
+		return nil, fmt.Errorf("%q expands to more than %d names", name, maxExpandedNames)
+	}
+	out := make([]string, 0, len(variants)*len(rest))
+	for _, v := range variants {
+		for _, r := range rest {
+			out = append(out, name[:start]+v+r)
+		}
+	}
+	return out, nil
+}
+
+func expandRange(body string) ([]string, error) {
+	bounds := strings.Split(body, ":")
+	if len(bounds) != 2 || bounds[0] == "" || bounds[1] == "" {
+		return nil, fmt.Errorf("range must look like [from:to]")
+	}
+	from, to := bounds[0], bounds[1]
+
+	fromNum, errFrom := strconv.Atoi(from)
+	toNum, errTo := strconv.Atoi(to)
+	if errFrom == nil && errTo == nil {
+		if fromNum > toNum {
+			return nil, fmt.Errorf("range [%s] is descending", body)
+		}
+		// unsigned, as the difference of huge bounds overflows int
+		if uint64(toNum-fromNum) >= maxExpandedNames {
+			return nil, fmt.Errorf("range [%s] has more than %d items", body, maxExpandedNames)
+		}
+		width := 0
+		if len(from) > 1 && from[0] == '0' {
+			width = len(from)
+		}
+		out := make([]string, 0, toNum-fromNum+1)
+		for i := fromNum; i <= toNum; i++ {
+			out = append(out, fmt.Sprintf("%0*d", width, i))
+		}
+		return out, nil
+	}
+
+	if len(from) == 1 && len(to) == 1 && isLetter(from[0]) && isLetter(to[0]) {
+		if from[0] > to[0] {
+			return nil, fmt.Errorf("range [%s] is descending", body)
+		}
+		out := make([]string, 0, to[0]-from[0]+1)
+		for c := from[0]; c <= to[0]; c++ {
+			out = append(out, string(c))
+		}
+		return out, nil
+	}
+
+	return nil, fmt.Errorf("range [%s] must be numeric or of single letters", body)
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
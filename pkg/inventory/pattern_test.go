@@ -0,0 +1,51 @@
+package inventory
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExpandPattern(t *testing.T) {
+	for _, tc := range []struct {
+		pattern string
+		want    []string
+	}{
+		{"web", []string{"web"}},
+		{"web[01:03]", []string{"web01", "web02", "web03"}},
+		{"web[8:10]", []string{"web8", "web9", "web10"}},
+		{"rack[a:c]", []string{"racka", "rackb", "rackc"}},
+		{"db-{a,b}", []string{"db-a", "db-b"}},
+		{"{a,b}[1:2]", []string{"a1", "a2", "b1", "b2"}},
+	} {
+		got, err := ExpandPattern(tc.pattern)
+		if err != nil {
+			t.Errorf("%s: %s", tc.pattern, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s = %v, want %v", tc.pattern, got, tc.want)
+		}
+	}
+}
+
+func TestExpandPatternErrors(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, want string
+	}{
+		{"web[1:3", "unbalanced brackets"},
+		{"web]", "unbalanced brackets"},
+		{"web[3:1]", "descending"},
+		{"web[1]", "range must look like [from:to]"},
+		{"web[a:10]", "must be numeric or of single letters"},
+		{"web[{a,b}:c]", "nested patterns are not supported"},
+		{"web[1:9999999]", "has more than 10000 items"},
+		{"web[-9223372036854775808:9223372036854775807]", "has more than 10000 items"},
+		{"web[1:1000][1:1000]", "expands to more than 10000 names"},
+	} {
+		_, err := ExpandPattern(tc.pattern)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: got %v, want an error containing %q", tc.pattern, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package inventory
+
+import (
+	"golden/pkg/fsys"
+	"golden/pkg/rerrors"
+	"golden/pkg/sh"
+	"golden/pkg/varmap"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// PluginOutput is what an inventory plugin prints to stdout as JSON, e. g.:
+//
+//	{
+//	  "hosts": {"web01": {"ssh_hostname": "10.0.0.1"}},
+//	  "groups": {"web": ["web01"]},
+//	  "instances": {"nginx-web01": {"host": "web01", "app": "nginx"}},
+//	  "vars": {
+//	    "groups": {"web": {"log_level": "info"}},
+//	    "hosts": {"web01": {"rack": "a1"}},
+//	    "instances": {"nginx-web01": {"port": 8080}}
+//	  }
+//	}
+//
+// Every section is optional.
+type PluginOutput struct {
+	Hosts     HostsCollection     `yaml:"hosts"`
+	Groups    GroupsCollection    `yaml:"groups"`
+	Instances InstancesCollection `yaml:"instances"`
+	Vars      struct {
+		Groups    map[string]varmap.VarMap `yaml:"groups"`
+		Hosts     map[string]varmap.VarMap `yaml:"hosts"`
+		Instances map[string]varmap.VarMap `yaml:"instances"`
+	} `yaml:"vars"`
+}
+
+// Kinds of variables provided by plugins.
+const (
+	PluginVarsGroups    = "groups"
+	PluginVarsHosts     = "hosts"
+	PluginVarsInstances = "instances"
+)
+
+// GetPluginVars returns variables provided by inventory plugins for
+// a group, a host or an instance. Returns nil if there are none.
+func (inv *Inventory) GetPluginVars(kind, name string) varmap.VarMap {
+	return inv.pluginVars[kind][name]
+}
+
+// readPlugins runs every executable in a directory in alphabetical order
+// and merges its output into the inventory. Plugins run within their root.
+func (inv *Inventory) readPlugins(dir string) {
+	if !fsys.DoesDirExists(dir) {
+		return
+	}
+	files, err := fsys.GetFiles(dir)
+	if err != nil {
+		panic(rerrors.NewErrIo(dir, "listing inventory plugins", err))
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		path := filepath.Join(dir, file)
+		fi, err := os.Stat(path)
+		if err != nil {
+			panic(rerrors.NewErrIo(path, "inventory plugin", err))
+		}
+		if fi.Mode().Perm()&0111 == 0 {
+			continue
+		}
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			panic(err)
+		}
+		inv.mergePlugin(path, sh.MustGetOutputIn(filepath.Dir(dir), abs))
+	}
+}
+
+func (inv *Inventory) mergePlugin(path string, out []byte) {
+	data := PluginOutput{
+		Hosts:     HostsCollection{},
+		Groups:    NewGroupsCollection(),
+		Instances: NewInstancesCollection(),
+	}
+	if err := yaml.Unmarshal(out, &data); err != nil {
+		panic(rerrors.NewErrIo(path, "parsing output of inventory plugin", err))
+	}
+
+	inv.hosts.Merge(data.Hosts, path)
+	inv.groups.Merge(data.Groups, path)
+	inv.instances.Merge(data.Instances, path)
+
+	inv.mergePluginVars(PluginVarsGroups, data.Vars.Groups, path)
+	inv.mergePluginVars(PluginVarsHosts, data.Vars.Hosts, path)
+	inv.mergePluginVars(PluginVarsInstances, data.Vars.Instances, path)
+}
+
+func (inv *Inventory) mergePluginVars(kind string, vars map[string]varmap.VarMap, path string) {
+	if inv.pluginVars[kind] == nil {
+		inv.pluginVars[kind] = map[string]varmap.VarMap{}
+	}
+	for name, m := range vars {
+		if m == nil {
+			continue
+		}
+		m.SetSource(path)
+		m.SetPaths()
+		if existing, ok := inv.pluginVars[kind][name]; ok {
+			m = varmap.Merge(existing, m, varmap.ConflictResolutionError)
+		}
+		inv.pluginVars[kind][name] = m
+	}
+}
@@ -0,0 +1,340 @@
+package inventory
+
+import (
+	"fmt"
+	"golden/pkg/rerrors"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Selector expressions pick instances out of the inventory.
+//
+// Terms:
+//
+//	name            an instance, all instances of a host or of a group;
+//	                ranges and alternatives are expanded, see ExpandPattern
+//	group(name)     all instances of a group
+//	host(name)      all instances of a host
+//	app(name)       all instances of an app
+//	instance(name)  a single instance
+//	all, *          every instance
+//	( expr )        grouping
+//	!term           every instance except those of term
+//
+// Operators, applied strictly from left to right:
+//
+//	| , :      union
+//	& :&       intersection
+//	- :!       difference, "-" must be preceded by a space or a closing parenthesis
+//
+// E. g. "web:&prod:!canary" or "group(prod) & app(nginx) - host(db01)".
+type Selector interface {
+	eval(inv *Inventory) []*Instance
+}
+
+type ErrSelector struct {
+	expr string
+	pos  int
+	msg  string
+}
+
+func (e *ErrSelector) NiceError() string {
+	return fmt.Sprintf("Invalid selector: %s\n\t%s\n\t%s^", e.msg, e.expr, strings.Repeat(" ", e.pos))
+}
+
+func (e *ErrSelector) Error() string {
+	return e.NiceError()
+}
+
+type selectorOp int
+
+const (
+	opUnion selectorOp = iota
+	opIntersection
+	opDifference
+)
+
+type binarySelector struct {
+	op          selectorOp
+	left, right Selector
+}
+
+func (s *binarySelector) eval(inv *Inventory) []*Instance {
+	left := s.left.eval(inv)
+	right := s.right.eval(inv)
+	switch s.op {
+	case opUnion:
+		return unionInstances(left, right)
+	case opIntersection:
+		rightSet := instancesSet(right)
+		out := make([]*Instance, 0, len(left))
+		for _, inst := range left {
+			if _, ok := rightSet[inst.Name]; ok {
+				out = append(out, inst)
+			}
+		}
+		return out
+	case opDifference:
+		rightSet := instancesSet(right)
+		out := make([]*Instance, 0, len(left))
+		for _, inst := range left {
+			if _, ok := rightSet[inst.Name]; !ok {
+				out = append(out, inst)
+			}
+		}
+		return out
+	}
+	panic("unreachable")
+}
+
+type termSelector struct {
+	kind string
+	name string
+}
+
+func (s *termSelector) eval(inv *Inventory) []*Instance {
+	switch s.kind {
+	case "all":
+		return inv.sortedInstances(func(*Instance) bool { return true })
+	case "app":
+		insts := inv.sortedInstances(func(inst *Instance) bool { return inst.App == s.name })
+		if len(insts) == 0 {
+			panic(newErrUnknown("app", s.name))
+		}
+		return insts
+	case "instance":
+		if inst, ok := inv.instances[s.name]; ok {
+			return []*Instance{inst}
+		}
+	case "host":
+		if _, ok := inv.hosts[s.name]; ok {
+			return inv.hostInstances[s.name]
+		}
+	case "group":
+		if gr, ok := inv.groups[s.name]; ok {
+			out := make([]*Instance, 0, len(gr.List()))
+			for _, instName := range gr.List() {
+				out = append(out, inv.instances[instName])
+			}
+			return out
+		}
+	case "":
+		if _, ok := inv.instances[s.name]; ok {
+			return (&termSelector{"instance", s.name}).eval(inv)
+		}
+		if _, ok := inv.hosts[s.name]; ok {
+			return (&termSelector{"host", s.name}).eval(inv)
+		}
+		if _, ok := inv.groups[s.name]; ok {
+			return (&termSelector{"group", s.name}).eval(inv)
+		}
+		panic(newErrUnknown("instance/host/group", s.name))
+	}
+	panic(newErrUnknown(s.kind, s.name))
+}
+
+func newErrUnknown(kind, name string) *rerrors.ErrString {
+	return rerrors.NewErrStringf("Selector refers to unknown %s: %s", kind, name)
+}
+
+func (inv *Inventory) sortedInstances(filter func(*Instance) bool) []*Instance {
+	out := []*Instance{}
+	for _, inst := range inv.instances {
+		if filter(inst) {
+			out = append(out, inst)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+func unionInstances(left, right []*Instance) []*Instance {
+	out := make([]*Instance, 0, len(left)+len(right))
+	out = append(out, left...)
+	leftSet := instancesSet(left)
+	for _, inst := range right {
+		if _, ok := leftSet[inst.Name]; !ok {
+			leftSet[inst.Name] = struct{}{}
+			out = append(out, inst)
+		}
+	}
+	return out
+}
+
+func instancesSet(insts []*Instance) map[string]struct{} {
+	set := make(map[string]struct{}, len(insts))
+	for _, inst := range insts {
+		set[inst.Name] = struct{}{}
+	}
+	return set
+}
+
+type selectorParser struct {
+	expr string
+	pos  int
+}
+
+func ParseSelector(expr string) (sel Selector, err error) {
+	p := &selectorParser{expr: expr}
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			if selErr, ok := recovered.(*ErrSelector); ok {
+				sel, err = nil, selErr
+				return
+			}
+			panic(recovered)
+		}
+	}()
+	sel = p.parseExpr()
+	p.skipSpaces()
+	if p.pos != len(p.expr) {
+		p.fail("unexpected %q", p.expr[p.pos:])
+	}
+	return sel, nil
+}
+
+func (p *selectorParser) fail(format string, args ...interface{}) {
+	panic(&ErrSelector{expr: p.expr, pos: p.pos, msg: fmt.Sprintf(format, args...)})
+}
+
+func (p *selectorParser) skipSpaces() {
+	for p.pos < len(p.expr) && unicode.IsSpace(rune(p.expr[p.pos])) {
+		p.pos++
+	}
+}
+
+func (p *selectorParser) parseExpr() Selector {
+	left := p.parseUnary()
+	for {
+		op, ok := p.parseOperator()
+		if !ok {
+			return left
+		}
+		left = &binarySelector{op: op, left: left, right: p.parseUnary()}
+	}
+}
+
+func (p *selectorParser) parseOperator() (selectorOp, bool) {
+	p.skipSpaces()
+	if p.pos >= len(p.expr) {
+		return 0, false
+	}
+	rest := p.expr[p.pos:]
+	switch {
+	case strings.HasPrefix(rest, ":&"):
+		p.pos += 2
+		return opIntersection, true
+	case strings.HasPrefix(rest, ":!"):
+		p.pos += 2
+		return opDifference, true
+	}
+	switch rest[0] {
+	case '|', ',', ':':
+		p.pos++
+		return opUnion, true
+	case '&':
+		p.pos++
+		return opIntersection, true
+	case '-':
+		p.pos++
+		return opDifference, true
+	}
+	return 0, false
+}
+
+func (p *selectorParser) parseUnary() Selector {
+	p.skipSpaces()
+	if p.pos < len(p.expr) && p.expr[p.pos] == '!' {
+		p.pos++
+		return &binarySelector{op: opDifference, left: &termSelector{kind: "all"}, right: p.parseUnary()}
+	}
+	return p.parsePrimary()
+}
+
+func (p *selectorParser) parsePrimary() Selector {
+	p.skipSpaces()
+	if p.pos >= len(p.expr) {
+		p.fail("expected a name")
+	}
+	if p.expr[p.pos] == '(' {
+		p.pos++
+		sel := p.parseExpr()
+		p.expect(')')
+		return sel
+	}
+	name := p.parseName()
+	p.skipSpaces()
+	if p.pos < len(p.expr) && p.expr[p.pos] == '(' {
+		switch name {
+		case "group", "host", "app", "instance":
+		default:
+			p.fail("unknown function %s", name)
+		}
+		p.pos++
+		p.skipSpaces()
+		arg := p.parseName()
+		p.expect(')')
+		return p.expandTerm(name, arg)
+	}
+	if name == "all" || name == "*" {
+		return &termSelector{kind: "all"}
+	}
+	return p.expandTerm("", name)
+}
+
+// expandTerm turns a name with ranges or alternatives into a union of terms.
+func (p *selectorParser) expandTerm(kind, name string) Selector {
+	names, err := ExpandPattern(name)
+	if err != nil {
+		p.fail("%s", err)
+	}
+	var sel Selector = &termSelector{kind: kind, name: names[0]}
+	for _, n := range names[1:] {
+		sel = &binarySelector{op: opUnion, left: sel, right: &termSelector{kind: kind, name: n}}
+	}
+	return sel
+}
+
+func (p *selectorParser) expect(c byte) {
+	p.skipSpaces()
+	if p.pos >= len(p.expr) || p.expr[p.pos] != c {
+		p.fail("expected %q", c)
+	}
+	p.pos++
+}
+
+func isSelectorSpecial(c byte) bool {
+	return strings.IndexByte("()&|,:!", c) >= 0 || unicode.IsSpace(rune(c))
+}
+
+func (p *selectorParser) parseName() string {
+	start := p.pos
+	if p.pos < len(p.expr) && p.expr[p.pos] == '-' {
+		p.fail("names cannot start with \"-\"")
+	}
+	for p.pos < len(p.expr) && !isSelectorSpecial(p.expr[p.pos]) {
+		// ranges and alternatives may contain ":" and ","
+		if closing := map[byte]byte{'[': ']', '{': '}'}[p.expr[p.pos]]; closing != 0 {
+			end := strings.IndexByte(p.expr[p.pos:], closing)
+			if end == -1 {
+				p.fail("missing %q", closing)
+			}
+			p.pos += end
+		}
+		p.pos++
+	}
+	if start == p.pos {
+		p.fail("expected a name")
+	}
+	return p.expr[start:p.pos]
+}
+
+// Select returns instances matching a selector expression.
+func (inv *Inventory) Select(expr string) []*Instance {
+	sel, err := ParseSelector(expr)
+	if err != nil {
+		panic(err)
+	}
+	return sel.eval(inv)
+}
@@ -0,0 +1,127 @@
+package inventory
+
+import (
+	"golden/pkg/rerrors/rerrorstest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func readSelectorInventory(t *testing.T) *Inventory {
+	return ReadInventory(writeRoot(t, map[string]string{
+		"hosts.yml": "h1: {}\nh2: {}\ndb1: {}\n",
+		"instances.yml": "web1: {app: nginx, host: h1}\n" +
+			"web2: {app: nginx, host: h2}\n" +
+			"canary: {app: nginx, host: h2}\n" +
+			"db: {app: postgres, host: db1}\n",
+		"groups.yml": "web: [web1, web2, canary]\nprod: [h1, db1]\n",
+	}), "")
+}
+
+func TestSelect(t *testing.T) {
+	inv := readSelectorInventory(t)
+	for _, tc := range []struct {
+		expr string
+		want []string
+	}{
+		// terms
+		{"web1", []string{"web1"}},
+		{"h2", []string{"canary", "web2"}},
+		{"web", []string{"canary", "web1", "web2"}},
+		{"instance(db)", []string{"db"}},
+		{"host(h1)", []string{"web1"}},
+		{"group(prod)", []string{"db", "web1"}},
+		{"app(nginx)", []string{"canary", "web1", "web2"}},
+		{"all", []string{"canary", "db", "web1", "web2"}},
+		{"*", []string{"canary", "db", "web1", "web2"}},
+		// operators
+		{"web1 | db", []string{"web1", "db"}},
+		{"web1,db", []string{"web1", "db"}},
+		{"web1:db", []string{"web1", "db"}},
+		{"web & prod", []string{"web1"}},
+		{"web:&prod", []string{"web1"}},
+		{"web - canary", []string{"web1", "web2"}},
+		{"web -canary", []string{"web1", "web2"}},
+		{"web:!canary", []string{"web1", "web2"}},
+		{"(web)-canary", []string{"web1", "web2"}},
+		// exclusion
+		{"!web", []string{"db"}},
+		{"!!web", []string{"canary", "web1", "web2"}},
+		{"web & !canary", []string{"web1", "web2"}},
+		// strictly left to right
+		{"web1 | db & prod", []string{"web1", "db"}},
+		{"db | web2 & prod", []string{"db"}},
+		{"web - canary | canary", []string{"web1", "web2", "canary"}},
+		// parentheses
+		{"web - (canary | web2)", []string{"web1"}},
+		{"db | (web2 & prod)", []string{"db"}},
+		{"( ( web1 ) )", []string{"web1"}},
+		// patterns
+		{"web[1:2]", []string{"web1", "web2"}},
+		{"{db,canary}", []string{"db", "canary"}},
+		{"host(h[1:2])", []string{"web1", "canary", "web2"}},
+		{"web[1:2] , db", []string{"web1", "web2", "db"}},
+	} {
+		got := []string{}
+		for _, inst := range inv.Select(tc.expr) {
+			got = append(got, inst.Name)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%q selects %v, want %v", tc.expr, got, tc.want)
+		}
+	}
+}
+
+func TestParseSelectorErrors(t *testing.T) {
+	for _, tc := range []struct {
+		expr string
+		msg  string
+		pos  int
+	}{
+		{"", "expected a name", 0},
+		{"web &", "expected a name", 5},
+		{"web & ()", "expected a name", 7},
+		{"(web", `expected ')'`, 4},
+		{"web)", `unexpected ")"`, 3},
+		{"web web", `unexpected "web"`, 4},
+		{"foo(web)", "unknown function foo", 3},
+		{"host(h1", `expected ')'`, 7},
+		{"-web", `names cannot start with "-"`, 0},
+		{"web[1:3", `missing ']'`, 3},
+		{"web[3:1]", `range [3:1] is descending in "web[3:1]"`, 8},
+		{"host(h{1,2)", `missing '}'`, 6},
+	} {
+		_, err := ParseSelector(tc.expr)
+		selErr, ok := err.(*ErrSelector)
+		if !ok {
+			t.Errorf("%q: got %v, want an invalid selector", tc.expr, err)
+			continue
+		}
+		if selErr.msg != tc.msg || selErr.pos != tc.pos {
+			t.Errorf("%q: got %q at %d, want %q at %d", tc.expr, selErr.msg, selErr.pos, tc.msg, tc.pos)
+		}
+	}
+}
+
+func TestSelectUnknown(t *testing.T) {
+	inv := readSelectorInventory(t)
+	for expr, want := range map[string]string{
+		"nope":           "unknown instance/host/group: nope",
+		"web | app(php)": "unknown app: php",
+		"host(web1)":     "unknown host: web1",
+		"group(h1)":      "unknown group: h1",
+		"instance(h1)":   "unknown instance: h1",
+	} {
+		if err := rerrorstest.Message(func() { inv.Select(expr) }); !strings.Contains(err, want) {
+			t.Errorf("%q: got %q, want %q", expr, err, want)
+		}
+	}
+}
+
+func TestErrSelectorPointsAtPosition(t *testing.T) {
+	_, err := ParseSelector("web & ()")
+	want := "Invalid selector: expected a name\n\tweb & ()\n\t       ^"
+	if err == nil || err.Error() != want {
+		t.Errorf("got %q, want %q", err, want)
+	}
+}
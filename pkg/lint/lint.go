@@ -0,0 +1,319 @@
+package lint
+
+import (
+	"fmt"
+	"golden/pkg/deployer"
+	"golden/pkg/inventory"
+	"golden/pkg/rerrors"
+	"golden/pkg/resolver"
+	"golden/pkg/root"
+	"golden/pkg/rtemplate"
+	"golden/pkg/varmap"
+	"sort"
+	"strings"
+)
+
+// Run checks instances without deploying them and collects all problems:
+//   - errors resolving variables
+//   - templates which do not parse
+//   - references to undefined variables in templates and templated variables
+//   - variables defined only in app_vars or instance_vars and never referred to
+//   - definitions in group_vars, host_vars and instance_vars of the instances
+//     which never take effect for any instance of the inventory, as they are
+//     always overridden
+//   - variables defined by groups of the same priority and depth, which
+//     win over each other only by names of the groups
+//
+// It returns nil if there are no problems.
+func Run(rs root.Roots, inv *inventory.Inventory, r *resolver.Resolver, insts []*inventory.Instance) *ErrProblems {
+	l := &linter{problems: map[string][]string{}}
+
+	resolved := []*inventory.Instance{}
+	for _, inst := range insts {
+		ok := l.catch(inst.Name, func() {
+			r.ResolveInstance(inst)
+			inv.OverrideHost(inst.Host, r.ResolveHost(inst))
+		})
+		if ok {
+			resolved = append(resolved, inst)
+		}
+	}
+
+	resolvedVars, substitutionErrors := r.GetAllResolvedVarsAndErrors()
+	d := deployer.New(rs, resolvedVars, substitutionErrors, inv)
+	d.SetTemplateFuncs(r.Funcs())
+
+	definitions := map[definition]*shadowing{}
+	conflicts := map[groupConflict]struct{}{}
+	for _, inst := range resolved {
+		used := map[string]struct{}{}
+		l.catch(inst.Name, func() {
+			for _, t := range d.Templates(inst) {
+				if t.Err != nil {
+					l.add(inst.Name, fmt.Sprintf("%s: does not parse: %s", t.Name, t.Err))
+					continue
+				}
+				l.checkReferences(inst.Name, t.Name, rtemplate.References(t.Tree, t.Partials), t.Vars, used)
+			}
+		})
+
+		explanations := r.ExplainInstance(inst)
+		vars := resolvedVars[inst.Name]
+		for _, e := range explanations {
+			v := e.Winner().Var
+			s, ok := v.Value.(string)
+			if !ok || !strings.Contains(s, "{{") {
+				continue
+			}
+			t, err := rtemplate.New(e.Path).Parse(s)
+			if err != nil {
+				l.add(inst.Name, fmt.Sprintf("%s: %s: does not parse: %s", v.Location(), e.Path, err))
+				continue
+			}
+			name := v.Location() + ": " + e.Path
+			l.checkReferences(inst.Name, name, rtemplate.References(t.Tree, nil), []map[string]interface{}{vars}, used)
+		}
+
+		for _, e := range explanations {
+			if definedInAppOrInstanceOnly(e) && !isUsed(e.Path, used) {
+				l.add(inst.Name, fmt.Sprintf("%s: defined in %s is never used", e.Path, e.Winner().Var.Location()))
+			}
+		}
+		collectShadowings(explanations, definitions, true)
+		collectGroupConflicts(explanations, conflicts)
+	}
+
+	// a definition of a group or a host may take effect for instances
+	// which are not linted
+	isSelected := make(map[string]bool, len(insts))
+	for _, inst := range insts {
+		isSelected[inst.Name] = true
+	}
+	for name, inst := range inv.GetAllInstances() {
+		if isSelected[name] {
+			continue
+		}
+		var explanations []*resolver.Explanation
+		// instances which do not resolve are reported when linted themselves
+		ok := (&rerrors.Collector{}).Catch(func() { explanations = r.ExplainInstance(inst) })
+		if ok {
+			collectShadowings(explanations, definitions, false)
+		}
+	}
+
+	keys := make([]definition, 0, len(definitions))
+	for key := range definitions {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].location != keys[j].location {
+			return keys[i].location < keys[j].location
+		}
+		return keys[i].path < keys[j].path
+	})
+	for _, key := range keys {
+		if s := definitions[key]; !s.tookEffect {
+			l.add(s.file, fmt.Sprintf("%s: %s never takes effect, overridden by %s", key.location, key.path, s.overriddenBy))
+		}
+	}
+
+	for _, c := range sortedConflicts(conflicts) {
+		l.add(c.loserVar.Source, fmt.Sprintf(
+			"%s: %s of group %s conflicts with %s of group %s of the same priority and depth, %s wins by name",
+			c.loserVar.Location(), c.path, c.loserGroup, c.winnerVar.Location(), c.winnerGroup, c.winnerGroup,
+		))
+	}
+
+	if len(l.problems) == 0 {
+		return nil
+	}
+	return &ErrProblems{Problems: l.problems}
+}
+
+// collectShadowings records whether definitions of groups, hosts and
+// instances take effect. Only linted instances add definitions.
+func collectShadowings(explanations []*resolver.Explanation, definitions map[definition]*shadowing, linted bool) {
+	for _, e := range explanations {
+		for _, def := range e.Definitions {
+			switch def.Layer.Source {
+			case resolver.VarSourceGroup, resolver.VarSourceHost, resolver.VarSourceInstance:
+			default:
+				continue
+			}
+			key := definition{e.Path, def.Var.Location()}
+			s, ok := definitions[key]
+			if !ok {
+				if !linted {
+					continue
+				}
+				s = &shadowing{file: def.Var.Source}
+				definitions[key] = s
+			}
+			if def == e.Winner() {
+				s.tookEffect = true
+			} else if s.overriddenBy == "" {
+				s.overriddenBy = e.Winner().Var.Location()
+			}
+		}
+	}
+}
+
+// collectGroupConflicts records definitions of groups of the same priority
+// and depth, of which the one of the group with the greater name wins.
+func collectGroupConflicts(explanations []*resolver.Explanation, conflicts map[groupConflict]struct{}) {
+	for _, e := range explanations {
+		for i, loser := range e.Definitions {
+			if loser.Layer.Source != resolver.VarSourceGroup {
+				continue
+			}
+			for _, winner := range e.Definitions[i+1:] {
+				if winner.Layer.Source == resolver.VarSourceGroup &&
+					winner.Layer.Priority == loser.Layer.Priority && winner.Layer.Depth == loser.Layer.Depth {
+					conflicts[groupConflict{e.Path, loser.Layer.Name, winner.Layer.Name, loser.Var, winner.Var}] = struct{}{}
+				}
+			}
+		}
+	}
+}
+
+func sortedConflicts(conflicts map[groupConflict]struct{}) []groupConflict {
+	out := make([]groupConflict, 0, len(conflicts))
+	for c := range conflicts {
+		out = append(out, c)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if a, b := out[i].loserVar.Location(), out[j].loserVar.Location(); a != b {
+			return a < b
+		}
+		return out[i].winnerVar.Location() < out[j].winnerVar.Location()
+	})
+	return out
+}
+
+type linter struct {
+	// problems by instance names or files
+	problems map[string][]string
+}
+
+type definition struct {
+	path     string
+	location string
+}
+
+// groupConflict is a variable of a group which is overridden by
+// a variable of another group only because of its name.
+type groupConflict struct {
+	path                    string
+	loserGroup, winnerGroup string
+	loserVar, winnerVar     *varmap.Var
+}
+
+type shadowing struct {
+	file         string
+	tookEffect   bool
+	overriddenBy string
+}
+
+func (l *linter) add(subject, problem string) {
+	for _, p := range l.problems[subject] {
+		if p == problem {
+			return
+		}
+	}
+	l.problems[subject] = append(l.problems[subject], problem)
+}
+
+// catch collects a nice error f panics with instead of stopping.
+// It reports whether f succeeded.
+func (l *linter) catch(subject string, f func()) (ok bool) {
+	defer func() {
+		recovered := recover()
+		if recovered == nil {
+			return
+		}
+		niceErr, isNice := recovered.(rerrors.NiceError)
+		if !isNice {
+			panic(recovered)
+		}
+		l.add(subject, niceErr.NiceError())
+		ok = false
+	}()
+	f()
+	return true
+}
+
+func (l *linter) checkReferences(inst, name string, refs []string, varsList []map[string]interface{}, used map[string]struct{}) {
+	for _, ref := range refs {
+		used[ref] = struct{}{}
+		for _, vars := range varsList {
+			if !isDefined(vars, ref) {
+				l.add(inst, fmt.Sprintf("%s: .%s is undefined", name, ref))
+				break
+			}
+		}
+	}
+}
+
+// isDefined reports whether a dotted path exists within vars. A path
+// going through a value which is not a map can not be checked statically
+// and is considered defined.
+func isDefined(vars map[string]interface{}, path string) bool {
+	var cur interface{} = vars
+	for _, key := range strings.Split(path, ".") {
+		m, ok := cur.(map[string]interface{})
+		if !ok {
+			return true
+		}
+		if cur, ok = m[key]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// isUsed reports whether a variable or a map containing it is referred to.
+func isUsed(path string, used map[string]struct{}) bool {
+	for ref := range used {
+		if ref == path || strings.HasPrefix(path, ref+".") || strings.HasPrefix(ref, path+".") {
+			return true
+		}
+	}
+	return false
+}
+
+func definedInAppOrInstanceOnly(e *resolver.Explanation) bool {
+	for _, def := range e.Definitions {
+		if def.Layer.Source != resolver.VarSourceApp && def.Layer.Source != resolver.VarSourceInstance {
+			return false
+		}
+	}
+	return true
+}
+
+type ErrProblems struct {
+	// Problems by instance names or files
+	Problems map[string][]string
+}
+
+func (e *ErrProblems) NiceError() string {
+	subjects := make([]string, 0, len(e.Problems))
+	count := 0
+	for subject, problems := range e.Problems {
+		subjects = append(subjects, subject)
+		count += len(problems)
+	}
+	sort.Strings(subjects)
+	b := strings.Builder{}
+	fmt.Fprintf(&b, "Lint found %d problems:", count)
+	for _, subject := range subjects {
+		fmt.Fprintf(&b, "\n%s:", subject)
+		for _, p := range e.Problems[subject] {
+			fmt.Fprintf(&b, "\n\t%s", strings.ReplaceAll(p, "\n", "\n\t\t"))
+		}
+	}
+	return b.String()
+}
+
+func (e *ErrProblems) Error() string {
+	return e.NiceError()
+}
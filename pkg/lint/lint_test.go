@@ -0,0 +1,49 @@
+package lint
+
+import (
+	"golden/pkg/inventory"
+	"golden/pkg/resolver"
+	"golden/pkg/root"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRunReportsGroupConflicts(t *testing.T) {
+	dir := t.TempDir()
+	for file, content := range map[string]string{
+		"hosts.yml":          "h1: {}\n",
+		"instances.yml":      "i1: {app: app, host: h1}\ni2: {app: app, host: h1}\n",
+		"groups.yml":         "a: [i1, i2]\nb: [i1, i2]\nc: {priority: 1, members: [i1]}\n",
+		"group_vars/a.yml":   "port: 1\nname: a\n",
+		"group_vars/b.yml":   "port: 2\n",
+		"group_vars/c.yml":   "name: c\n",
+		"apps/app/port.conf": "",
+	} {
+		path := filepath.Join(dir, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rs := root.Roots{dir}
+	inv := inventory.ReadInventory(rs, "")
+	insts := []*inventory.Instance{inv.GetAllInstances()["i1"], inv.GetAllInstances()["i2"]}
+
+	errs := Run(rs, inv, resolver.New(rs, inv), insts)
+	if errs == nil {
+		t.Fatal("got no problems, want a conflict of groups a and b")
+	}
+	a := filepath.Join(dir, "group_vars", "a.yml")
+	b := filepath.Join(dir, "group_vars", "b.yml")
+	want := []string{
+		a + ":1:1: port never takes effect, overridden by " + b + ":1:1",
+		a + ":1:1: port of group a conflicts with " + b + ":1:1 of group b of the same priority and depth, b wins by name",
+	}
+	if got := errs.Problems[a]; !reflect.DeepEqual(got, want) {
+		t.Errorf("got problems of a.yml %q, want %q", got, want)
+	}
+}
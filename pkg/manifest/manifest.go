@@ -3,11 +3,37 @@ package manifest
 import (
 	"golden/pkg/rerrors"
 	"golden/pkg/ryaml"
+	"sort"
+
+	"gopkg.in/yaml.v3"
 )
 
+// Manifest lists selector expressions of instances to deploy.
+type Manifest struct {
+	Selectors []string
+	// Positions of selectors within a manifests file, empty for
+	// a manifest which is not read from a file
+	Positions []rerrors.Pos
+}
 
+// UnmarshalYAML records positions of selectors.
+func (m *Manifest) UnmarshalYAML(node *yaml.Node) error {
+	if err := node.Decode(&m.Selectors); err != nil {
+		return err
+	}
+	for _, item := range node.Content {
+		m.Positions = append(m.Positions, rerrors.Pos{Line: item.Line, Col: item.Column})
+	}
+	return nil
+}
 
-type Manifest []string
+// SelectorPos returns the position of the i-th selector, if it is known.
+func (m *Manifest) SelectorPos(i int) (rerrors.Pos, bool) {
+	if i >= len(m.Positions) {
+		return rerrors.Pos{}, false
+	}
+	return m.Positions[i], true
+}
 
 type ManifestsCollection map[string]*Manifest
 
@@ -15,23 +41,55 @@ func NewManifestsCollection() ManifestsCollection {
 	return ManifestsCollection{}
 }
 
-func ReadManifestsCollection(fileBaseNameOrDir string) ManifestsCollection {
-	filenamesList := []string{}
-	maps := ryaml.ReadYamlRecursive(fileBaseNameOrDir, func(filename string) interface{} {
-		filenamesList = append(filenamesList, filename)
-		return NewManifestsCollection()
+// manifestsFile is a file of manifests with positions of their definitions.
+type manifestsFile struct {
+	manifests ManifestsCollection
+	positions map[string]rerrors.Pos
+}
+
+func (f *manifestsFile) UnmarshalYAML(node *yaml.Node) error {
+	return ryaml.DecodeMapping(node, func(key, value *yaml.Node) error {
+		manif := &Manifest{}
+		f.manifests[key.Value] = manif
+		f.positions[key.Value] = rerrors.Pos{Line: key.Line, Col: key.Column}
+		return value.Decode(manif)
 	})
+}
 
+// ReadManifestsCollection reads manifests of every file or directory,
+// e. g. of every root.
+func ReadManifestsCollection(fileBaseNameOrDirs ...string) ManifestsCollection {
 	c := NewManifestsCollection()
 	sources := map[string]string{}
-	for i, m := range maps {
-		for name, manif := range m.(ManifestsCollection) {
-			if _, ok := c[name]; ok {
-				panic(rerrors.NewErrDuplicate(name, "instance", sources[name], filenamesList[i]))
+	errs := &rerrors.Collector{}
+	for _, fileBaseNameOrDir := range fileBaseNameOrDirs {
+		filenamesList := []string{}
+		maps := ryaml.CollectYamlRecursive(fileBaseNameOrDir, func(filename string) interface{} {
+			filenamesList = append(filenamesList, filename)
+			return &manifestsFile{NewManifestsCollection(), map[string]rerrors.Pos{}}
+		}, errs)
+		for i, m := range maps {
+			f := m.(*manifestsFile)
+			names := make([]string, 0, len(f.manifests))
+			for name := range f.manifests {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				pos := f.positions[name]
+				pos.File = filenamesList[i]
+				if _, ok := c[name]; ok {
+					errs.Add(rerrors.NewErrDuplicate(name, "manifest", sources[name], pos.String()))
+					continue
+				}
+				for j := range f.manifests[name].Positions {
+					f.manifests[name].Positions[j].File = filenamesList[i]
+				}
+				c[name] = f.manifests[name]
+				sources[name] = pos.String()
 			}
-			c[name] = manif
-			sources[name] = filenamesList[i]
 		}
 	}
+	errs.PanicIfAny()
 	return c
 }
\ No newline at end of file
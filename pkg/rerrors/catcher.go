@@ -18,23 +18,23 @@ func Recover(recovered interface{}, ok *bool) {
 
 	switch err := recovered.(type) {
 	case NiceError:
-		fmt.Fprintln(os.Stderr, err.NiceError())
+		fmt.Fprintln(os.Stderr, Redact(err.NiceError()))
 		return
 	case *os.PathError:
-		fmt.Fprintln(os.Stderr, err.Error())
+		fmt.Fprintln(os.Stderr, Redact(err.Error()))
 		return
 	case *exec.ExitError:
 		fmt.Fprintf(os.Stderr, "Subcommand exited with satus: %d\n", err.ExitCode())
 		return
 	case template.ExecError:
-		fmt.Fprintf(os.Stderr, "Template execution failed:\n%s\n", err.Err.Error())
+		fmt.Fprintf(os.Stderr, "Template execution failed:\n%s\n", Redact(err.Err.Error()))
 		return
 	}
 
 
 	fmt.Fprintf(
 		os.Stderr,
-		"Unexpected error occurred!\nError: %v\nFile a bug report: %s\nStack:%s",
-		recovered, "github.com/prodev-live/golden/issues", debug.Stack(),
+		"Unexpected error occurred!\nError: %s\nFile a bug report: %s\nStack:%s",
+		Redact(fmt.Sprint(recovered)), "github.com/prodev-live/golden/issues", debug.Stack(),
 	)
 }
\ No newline at end of file
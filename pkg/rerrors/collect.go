@@ -0,0 +1,116 @@
+package rerrors
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Pos is a position within a file. Line and Col start with 1,
+// they are 0 if unknown.
+type Pos struct {
+	File string
+	Line int
+	Col  int
+}
+
+func (p Pos) String() string {
+	switch {
+	case p.Line == 0:
+		return p.File
+	case p.Col == 0:
+		return fmt.Sprintf("%s:%d", p.File, p.Line)
+	}
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Col)
+}
+
+// ErrAt is an error at a position within a file.
+type ErrAt struct {
+	Pos Pos
+	Msg string
+}
+
+func NewErrAt(pos Pos, format string, args ...interface{}) *ErrAt {
+	return &ErrAt{pos, fmt.Sprintf(format, args...)}
+}
+
+func (e *ErrAt) NiceError() string {
+	return fmt.Sprintf("%s: %s", e.Pos, e.Msg)
+}
+
+func (e *ErrAt) Error() string {
+	return e.NiceError()
+}
+
+// Errors are all errors of a run, e. g. of reading configuration,
+// reported at once.
+type Errors []NiceError
+
+func (e Errors) NiceError() string {
+	if len(e) == 1 {
+		return e[0].NiceError()
+	}
+	b := strings.Builder{}
+	fmt.Fprintf(&b, "%d errors:", len(e))
+	for _, err := range e {
+		b.WriteString("\n- ")
+		b.WriteString(strings.ReplaceAll(err.NiceError(), "\n", "\n  "))
+	}
+	return b.String()
+}
+
+func (e Errors) Error() string {
+	return e.NiceError()
+}
+
+// Collector collects errors to report all of them instead of the first one.
+// The zero value is ready to use.
+type Collector struct {
+	errs Errors
+	seen map[string]struct{}
+}
+
+// Add adds an error, flattening Errors. An error with the same
+// message as an already added one is dropped.
+func (c *Collector) Add(err NiceError) {
+	if errs, ok := err.(Errors); ok {
+		for _, e := range errs {
+			c.Add(e)
+		}
+		return
+	}
+	if c.seen == nil {
+		c.seen = map[string]struct{}{}
+	}
+	msg := err.NiceError()
+	if _, ok := c.seen[msg]; ok {
+		return
+	}
+	c.seen[msg] = struct{}{}
+	c.errs = append(c.errs, err)
+}
+
+// Catch runs f and adds a NiceError it panics with. Other panics are
+// propagated. It reports whether f succeeded.
+func (c *Collector) Catch(f func()) (ok bool) {
+	defer func() {
+		recovered := recover()
+		if recovered == nil {
+			return
+		}
+		err, isNice := recovered.(NiceError)
+		if !isNice {
+			panic(recovered)
+		}
+		c.Add(err)
+		ok = false
+	}()
+	f()
+	return true
+}
+
+// PanicIfAny panics with all collected errors if there are any.
+func (c *Collector) PanicIfAny() {
+	if len(c.errs) != 0 {
+		panic(c.errs)
+	}
+}
@@ -0,0 +1,41 @@
+package rerrors
+
+import (
+	"sort"
+	"strings"
+)
+
+// Redacted replaces secret values in output.
+const Redacted = "<redacted>"
+
+// minSecretLen keeps short values such as "1" or "yes" from being
+// redacted everywhere they occur. Secret variables themselves are
+// redacted by their Secret flag whatever their length, Redact catches
+// secrets within other values and errors.
+const minSecretLen = 4
+
+var secrets = map[string]struct{}{}
+
+// AddSecret registers a decrypted value to be redacted in errors and output.
+func AddSecret(s string) {
+	if len(s) >= minSecretLen {
+		secrets[s] = struct{}{}
+	}
+}
+
+// Redact replaces every registered secret within s.
+func Redact(s string) string {
+	if len(secrets) == 0 {
+		return s
+	}
+	// longer secrets first, so that a secret containing another one is redacted whole
+	sorted := make([]string, 0, len(secrets))
+	for secret := range secrets {
+		sorted = append(sorted, secret)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return len(sorted[i]) > len(sorted[j]) })
+	for _, secret := range sorted {
+		s = strings.ReplaceAll(s, secret, Redacted)
+	}
+	return s
+}
@@ -0,0 +1,23 @@
+// Package rerrorstest helps testing functions which report errors
+// by panicking with nice errors.
+package rerrorstest
+
+import "golden/pkg/rerrors"
+
+// Message returns the message of a nice error f panics with or ""
+// if it does not panic. Other panics are propagated.
+func Message(f func()) (msg string) {
+	defer func() {
+		recovered := recover()
+		if recovered == nil {
+			return
+		}
+		niceErr, ok := recovered.(rerrors.NiceError)
+		if !ok {
+			panic(recovered)
+		}
+		msg = niceErr.NiceError()
+	}()
+	f()
+	return ""
+}
@@ -0,0 +1,72 @@
+package resolver
+
+import (
+	"fmt"
+	"golden/pkg/rerrors"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// Funcs returns template functions looking up other instances.
+// They replace stubs of the same name in rtemplate.Funcs:
+//
+//	instances_in_group GROUP  sorted names of instances of a group
+//	hosts_in_group GROUP      sorted names of hosts of a group
+//	instance_vars INSTANCE    resolved variables of an instance
+//
+// Instances are resolved lazily. An instance referring to itself,
+// directly or via other instances, is an error.
+func (r *Resolver) Funcs() template.FuncMap {
+	return template.FuncMap{
+		"instances_in_group": r.instancesInGroup,
+		"hosts_in_group":     r.hostsInGroup,
+		"instance_vars":      r.otherInstanceVars,
+	}
+}
+
+func (r *Resolver) instancesInGroup(group string) ([]string, error) {
+	gr := r.inv.GetGroup(group)
+	if gr == nil {
+		return nil, fmt.Errorf("unknown group %s", group)
+	}
+	out := append([]string{}, gr.List()...)
+	sort.Strings(out)
+	return out, nil
+}
+
+func (r *Resolver) hostsInGroup(group string) ([]string, error) {
+	if r.inv.GetGroup(group) == nil {
+		return nil, fmt.Errorf("unknown group %s", group)
+	}
+	return r.inv.GetGroupHosts(group), nil
+}
+
+func (r *Resolver) otherInstanceVars(name string) (vars map[string]interface{}, err error) {
+	inst := r.inv.GetAllInstances()[name]
+	if inst == nil {
+		return nil, fmt.Errorf("unknown instance %s", name)
+	}
+	for i, resolving := range r.resolving {
+		if resolving == name {
+			chain := append(append([]string{}, r.resolving[i:]...), name)
+			return nil, fmt.Errorf("cyclic reference between instances: %s", strings.Join(chain, " -> "))
+		}
+	}
+	// text/template turns panics of functions into plain errors,
+	// keep the nice message of errors of the instance being resolved
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			if nice, ok := recovered.(rerrors.NiceError); ok {
+				err = fmt.Errorf("resolving instance %s: %s", name, nice.NiceError())
+				return
+			}
+			panic(recovered)
+		}
+	}()
+	vars, substErr := r.ResolveInstance(inst)
+	if substErr != nil {
+		return nil, fmt.Errorf("resolving instance %s: %s", name, substErr.NiceError())
+	}
+	return vars, nil
+}
@@ -0,0 +1,85 @@
+package resolver
+
+import (
+	"golden/pkg/inventory"
+	"golden/pkg/rerrors/rerrorstest"
+	"golden/pkg/root"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInstanceVarsCycles(t *testing.T) {
+	r, inv := newTestResolver(t, map[string]string{
+		"hosts.yml":              "h1: {}\n",
+		"instances.yml":          "self: {app: app, host: h1}\na: {app: app, host: h1}\nb: {app: app, host: h1}\n",
+		"instance_vars/self.yml": "port: 1\nloop: '{{ (instance_vars \"self\").port }}'\n",
+		"instance_vars/a.yml":    "port: 1\nfrom_b: '{{ (instance_vars \"b\").port }}'\n",
+		"instance_vars/b.yml":    "port: '{{ (instance_vars \"a\").port }}'\n",
+	})
+	for inst, want := range map[string]string{
+		"self": "cyclic reference between instances: self -> self",
+		"a":    "cyclic reference between instances: a -> b -> a",
+	} {
+		if err := rerrorstest.Message(func() { r.ResolveInstance(inv.GetAllInstances()[inst]) }); !strings.Contains(err, want) {
+			t.Errorf("%s: got %q, want %q", inst, err, want)
+		}
+		if len(r.resolving) != 0 {
+			t.Errorf("%s: instances %v are left being resolved", inst, r.resolving)
+		}
+	}
+}
+
+func TestInstanceVarsReportsCycle(t *testing.T) {
+	r, _ := newTestResolver(t, map[string]string{
+		"hosts.yml":     "h1: {}\n",
+		"instances.yml": "a: {app: app, host: h1}\nb: {app: app, host: h1}\n",
+	})
+	for _, tc := range []struct {
+		resolving []string
+		name      string
+		want      string
+	}{
+		{[]string{"a"}, "a", "cyclic reference between instances: a -> a"},
+		{[]string{"a", "b"}, "a", "cyclic reference between instances: a -> b -> a"},
+		{[]string{"a", "b"}, "b", "cyclic reference between instances: b -> b"},
+	} {
+		r.resolving = tc.resolving
+		_, err := r.otherInstanceVars(tc.name)
+		if err == nil || err.Error() != tc.want {
+			t.Errorf("instance_vars %q while resolving %v: got %v, want %q", tc.name, tc.resolving, err, tc.want)
+		}
+	}
+}
+
+func TestHostsInGroupOfUnknownGroup(t *testing.T) {
+	r, inv := newTestResolver(t, map[string]string{
+		"hosts.yml":     "h1: {}\n",
+		"instances.yml": "a: {app: app, host: h1}\n",
+	})
+	if hosts := inv.GetGroupHosts("nope"); hosts != nil {
+		t.Errorf("got %v, want no hosts", hosts)
+	}
+	if _, err := r.hostsInGroup("nope"); err == nil || err.Error() != "unknown group nope" {
+		t.Errorf("got %v, want an unknown group", err)
+	}
+}
+
+// newTestResolver writes files of a root to a temporary directory
+// and reads its inventory.
+func newTestResolver(t *testing.T, files map[string]string) (*Resolver, *inventory.Inventory) {
+	dir := t.TempDir()
+	for file, content := range files {
+		path := filepath.Join(dir, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rs := root.Roots{dir}
+	inv := inventory.ReadInventory(rs, "")
+	return New(rs, inv), inv
+}
@@ -1,9 +1,15 @@
 package resolver
 
 import (
+	"fmt"
+	"golden/pkg/apps"
 	"golden/pkg/inventory"
+	"golden/pkg/rerrors"
+	"golden/pkg/root"
 	"golden/pkg/varmap"
 	"path/filepath"
+	"sort"
+	"strings"
 )
 
 type VarSource int
@@ -13,28 +19,85 @@ const (
 	VarSourceHost
 	VarSourceApp
 	VarSourceInstance
+	VarSourceCli
+	VarSourceBuiltin
 )
 
+func (s VarSource) String() string {
+	switch s {
+	case VarSourceCommon:
+		return "common"
+	case VarSourceGroup:
+		return "group"
+	case VarSourceHost:
+		return "host"
+	case VarSourceApp:
+		return "app"
+	case VarSourceInstance:
+		return "instance"
+	case VarSourceCli:
+		return "cli"
+	case VarSourceBuiltin:
+		return "builtin"
+	}
+	panic("unreachable")
+}
+
+// Layer is a single set of variables participating in resolution of an instance.
+type Layer struct {
+	Source   VarSource
+	Name     string
+	Priority int
+	Depth    int
+	Vars     varmap.VarMap
+}
+
+func (l *Layer) String() string {
+	if l.Source == VarSourceGroup {
+		return fmt.Sprintf("%s %s (priority %d, depth %d)", l.Source, l.Name, l.Priority, l.Depth)
+	}
+	if l.Name == "" {
+		return l.Source.String()
+	}
+	return fmt.Sprintf("%s %s", l.Source, l.Name)
+}
 
-func New(rootDir string, inv *inventory.Inventory) *Resolver {
+func New(rs root.Roots, inv *inventory.Inventory) *Resolver {
 	r := &Resolver{
-		rootDir: rootDir,
+		roots: rs,
 		inv: inv,
 	}
 	return r
 }
 
 type Resolver struct {
-	rootDir string
+	roots root.Roots
 	inv *inventory.Inventory
 	commonVars varmap.VarMap
 	groupVars map[string]varmap.VarMap
 	hostVars map[string]varmap.VarMap
 	appVars map[string]varmap.VarMap
 	instanceVars map[string]varmap.VarMap
+	cliVars varmap.VarMap
+	env string
 
 	finalInstanceVars map[string]map[string]interface{}
 	varSubstitionErrors map[string]*varmap.ErrUnresolvedVariables
+	// instances being resolved, to detect cyclic references of instance_vars
+	resolving []string
+}
+
+// SetEnv sets an environment whose variables override variables
+// of the same kind, e. g. envs/staging/group_vars/web.yml overrides
+// group_vars/web.yml, but not host_vars.
+func (r *Resolver) SetEnv(env string) {
+	r.env = env
+}
+
+// SetCliVars sets variables overriding all others except builtins,
+// e. g. from --var and --vars-file.
+func (r *Resolver) SetCliVars(vars varmap.VarMap) {
+	r.cliVars = vars
 }
 
 func (r *Resolver) GetAllResolvedVarsAndErrors() (map[string]map[string]interface{}, map[string]*varmap.ErrUnresolvedVariables) {
@@ -45,13 +108,15 @@ func (r *Resolver) GetResolvedVars(instanceName string) map[string]interface{} {
 	return r.finalInstanceVars[instanceName]
 }
 
-func CreateBuiltInVars(inst *inventory.Instance) varmap.VarMap {
+func CreateBuiltInVars(inst *inventory.Instance, env string) varmap.VarMap {
 	m := varmap.New()
+	m["_env_"] = &varmap.Var{Value: env}
 	m["_host_"] = &varmap.Var{Value:inst.Host}
 	m["_app_"] = &varmap.Var{Value:inst.App}
 	m["_instance_"] = &varmap.Var{Value: inst.Name}
 	m["_install_prefix_"] = &varmap.Var{Value: inst.InstallPrefix}
 	m.SetSource("_builtin_")
+	m.SetPaths()
 	return m
 }
 
@@ -62,39 +127,261 @@ func (r *Resolver) ResolveInstance(inst *inventory.Instance) (finalVars map[stri
 	}
 	ok := false
 	if finalVars, ok = r.finalInstanceVars[inst.Name]; ok {
-		return finalVars, varSubstitionError
+		return finalVars, r.varSubstitionErrors[inst.Name]
 	}
 
+	r.resolving = append(r.resolving, inst.Name)
 	defer func() {
-		r.finalInstanceVars[inst.Name] = finalVars
-		r.varSubstitionErrors[inst.Name] = varSubstitionError
+		r.resolving = r.resolving[:len(r.resolving)-1]
 	}()
 
+	vars := mergeLayers(r.GetLayers(inst))
+
+	finalVars, varSubstitionError = vars.SubstituteTemplatedVarsWith(r.Funcs())
+
+	// only on a normal return, an instance failing to resolve fails every time
+	r.finalInstanceVars[inst.Name] = finalVars
+	r.varSubstitionErrors[inst.Name] = varSubstitionError
+	return finalVars, varSubstitionError
+}
+
+// mergeLayers merges layers in order, a later layer overrides an earlier
+// one. Groups are ordered by inventory.GetGroups, so of groups of the same
+// priority and depth the one with the greatest name wins.
+func mergeLayers(layers []*Layer) varmap.VarMap {
 	vars := varmap.New()
-	vars = varmap.Merge(vars, r.getCommonVars(), varmap.ConflictResolutionOverride)
-	vars = varmap.Merge(vars, r.getAppVars(inst.App), varmap.ConflictResolutionOverride)
+	for _, l := range layers {
+		cr := varmap.ConflictResolutionOverride
+		if l.Source == VarSourceBuiltin {
+			cr = varmap.ConflictResolutionError
+		}
+		vars = varmap.Merge(vars, l.Vars, cr)
+	}
+	return vars
+}
+
+// GetHostLayers returns variable layers visible to a host definition:
+// common, groups of the host, host, cli and the ._host_ and ._env_ builtins.
+func (r *Resolver) GetHostLayers(host string) []*Layer {
+	layers := []*Layer{{Source: VarSourceCommon, Vars: r.getCommonVars()}}
+	layers = append(layers, r.getGroupLayers(r.inv.GetHostGroups(host))...)
+	builtins := varmap.New()
+	builtins["_host_"] = &varmap.Var{Value: host}
+	builtins["_env_"] = &varmap.Var{Value: r.env}
+	builtins.SetSource("_builtin_")
+	builtins.SetPaths()
+	layers = append(layers,
+		&Layer{Source: VarSourceHost, Name: host, Vars: r.getHostVars(host)},
+		&Layer{Source: VarSourceCli, Vars: r.getCliVars()},
+		&Layer{Source: VarSourceBuiltin, Vars: builtins},
+	)
+	return layers
+}
+
+// ResolveHost returns the host definition of an instance with ssh_* fields
+// executed as templates against variables of GetHostLayers.
+func (r *Resolver) ResolveHost(inst *inventory.Instance) *inventory.Host {
+	h := r.inv.GetHost(inst.Host)
+	if h == nil {
+		panic(rerrors.NewErrStringf("host %s of instance %s is not defined", inst.Host, inst.Name))
+	}
+	if !h.IsTemplated() {
+		return h
+	}
+	vars, substErr := mergeLayers(r.GetHostLayers(inst.Host)).SubstituteTemplatedVars()
+	if substErr == nil {
+		return h.ExecTemplates(inst.Host, vars)
+	}
+	// a field referring to an unresolved variable fails with the reason
+	defer func() {
+		recovered := recover()
+		if recovered == nil {
+			return
+		}
+		if nice, ok := recovered.(rerrors.NiceError); ok {
+			panic(rerrors.Errors{nice, substErr})
+		}
+		panic(recovered)
+	}()
+	executed := h.ExecTemplates(inst.Host, vars)
+	if executed.IsTemplated() {
+		panic(substErr)
+	}
+	return executed
+}
+
+// GetLayers returns all variable layers of an instance from the lowest
+// precedence to the highest.
+func (r *Resolver) GetLayers(inst *inventory.Instance) []*Layer {
+	layers := []*Layer{
+		{Source: VarSourceCommon, Vars: r.getCommonVars()},
+	}
+	layers = append(layers, r.getAppLayers(inst.App)...)
+	layers = append(layers, r.getGroupLayers(r.inv.GetGroups(inst.Name))...)
+	layers = append(layers,
+		&Layer{Source: VarSourceHost, Name: inst.Host, Vars: r.getHostVars(inst.Host)},
+		&Layer{Source: VarSourceInstance, Name: inst.Name, Vars: r.getInstanceVars(inst.Name)},
+		&Layer{Source: VarSourceCli, Vars: r.getCliVars()},
+		&Layer{Source: VarSourceBuiltin, Vars: CreateBuiltInVars(inst, r.env)},
+	)
+	return layers
+}
 
-	groups := r.inv.GetGroups(inst.Name)
-	grMap := varmap.New()
+// getAppLayers returns app_vars of every app the app is composed of,
+// see apps.Layers. An app without a directory has its own app_vars only.
+func (r *Resolver) getAppLayers(app string) []*Layer {
+	if !apps.Exists(r.roots, app) {
+		return []*Layer{{Source: VarSourceApp, Name: app, Vars: r.getAppVars(app)}}
+	}
+	appLayers := apps.Layers(r.roots, app)
+	layers := make([]*Layer, 0, len(appLayers))
+	for _, l := range appLayers {
+		layers = append(layers, &Layer{Source: VarSourceApp, Name: l.Name, Vars: r.getAppVars(l.Name)})
+	}
+	return layers
+}
+
+func (r *Resolver) getGroupLayers(groups []string) []*Layer {
+	layers := make([]*Layer, 0, len(groups))
 	for _, gr := range groups {
-		grMap = varmap.Merge(grMap, r.getGroupVars(gr), varmap.ConflictResolutionError)
+		group := r.inv.GetGroup(gr)
+		layers = append(layers, &Layer{
+			Source:   VarSourceGroup,
+			Name:     gr,
+			Priority: group.Priority,
+			Depth:    group.Depth(),
+			Vars:     r.getGroupVars(gr),
+		})
 	}
+	return layers
+}
+
+// Definition is a single definition of a variable in one of the layers.
+type Definition struct {
+	Layer *Layer
+	Var   *varmap.Var
+}
 
-	vars = varmap.Merge(vars, grMap, varmap.ConflictResolutionOverride)
+// Explanation lists all definitions of a variable in precedence order.
+// The last definition is the one that wins.
+type Explanation struct {
+	Path        string
+	Definitions []*Definition
+}
 
-	host := inst.Host
-	vars = varmap.Merge(vars, r.getHostVars(host), varmap.ConflictResolutionOverride)
-	vars = varmap.Merge(vars, r.getInstanceVars(inst.Name), varmap.ConflictResolutionOverride)
-	vars = varmap.Merge(vars, CreateBuiltInVars(inst), varmap.ConflictResolutionError)
+func (e *Explanation) Winner() *Definition {
+	return e.Definitions[len(e.Definitions)-1]
+}
 
-	finalVars, varSubstitionError = vars.SubstituteTemplatedVars()
+// ExplainInstance traces every leaf variable of an instance through its layers.
+func (r *Resolver) ExplainInstance(inst *inventory.Instance) []*Explanation {
+	byPath := map[string]*Explanation{}
+	for _, l := range r.GetLayers(inst) {
+		collectDefinitions(l, l.Vars, byPath)
+	}
+	out := make([]*Explanation, 0, len(byPath))
+	for _, e := range byPath {
+		out = append(out, e)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Path < out[j].Path })
+	return out
+}
 
-	return finalVars, varSubstitionError
+// SourceOf returns a function returning a file defining a variable
+// of an instance, which wins over other definitions. Elements of lists
+// are defined where lists are, maps are defined where their first
+// variable is.
+func (r *Resolver) SourceOf(inst *inventory.Instance) func(path string) string {
+	explanations := r.ExplainInstance(inst)
+	sources := map[string]string{}
+	for _, e := range explanations {
+		sources[e.Path] = e.Winner().Var.Location()
+	}
+	return func(path string) string {
+		for _, e := range explanations {
+			if strings.HasPrefix(e.Path, path+".") {
+				return e.Winner().Var.Location()
+			}
+		}
+		for path != "" {
+			if source, ok := sources[path]; ok {
+				return source
+			}
+			i := strings.LastIndex(path, ".")
+			if i < 0 {
+				break
+			}
+			path = path[:i]
+		}
+		return "nowhere"
+	}
+}
+
+// IsSecret returns a function reporting whether a variable of an instance
+// is secret, i. e. the winning definition of it or of any variable within
+// it is encrypted. Values templated from secrets are not reported.
+func (r *Resolver) IsSecret(inst *inventory.Instance) func(path string) bool {
+	secrets := []string{}
+	for _, e := range r.ExplainInstance(inst) {
+		if e.Winner().Var.Secret {
+			secrets = append(secrets, e.Path)
+		}
+	}
+	return func(path string) bool {
+		for _, s := range secrets {
+			if s == path || strings.HasPrefix(s, path+".") {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func collectDefinitions(l *Layer, m varmap.VarMap, byPath map[string]*Explanation) {
+	for _, v := range m {
+		if sub, ok := v.Value.(varmap.VarMap); ok {
+			// a map replaces a value of a lower layer and wins over it
+			if e, ok := byPath[v.Path.String()]; ok {
+				e.Definitions = append(e.Definitions, &Definition{Layer: l, Var: v})
+			}
+			collectDefinitions(l, sub, byPath)
+			continue
+		}
+		path := v.Path.String()
+		e, ok := byPath[path]
+		if !ok {
+			e = &Explanation{Path: path}
+			byPath[path] = e
+		}
+		e.Definitions = append(e.Definitions, &Definition{Layer: l, Var: v})
+	}
+}
+
+// readVars reads variables, e. g. of "group_vars/web", of every root,
+// later roots override earlier ones. Variables of the environment
+// in every root override all of them.
+func (r *Resolver) readVars(path string) varmap.VarMap {
+	paths := r.roots.Paths(path)
+	if r.env != "" {
+		paths = append(paths, r.roots.Paths(filepath.Join(inventory.EnvsDir, r.env, path))...)
+	}
+	m := varmap.New()
+	for _, p := range paths {
+		m = varmap.Merge(m, varmap.Read(p), varmap.ConflictResolutionOverride)
+	}
+	return m
+}
+
+func (r *Resolver) getCliVars() varmap.VarMap {
+	if r.cliVars == nil {
+		r.cliVars = varmap.New()
+	}
+	return r.cliVars
 }
 
 func (r *Resolver) getCommonVars() varmap.VarMap {
 	if r.commonVars == nil {
-		r.commonVars = varmap.Read("common_vars")
+		r.commonVars = r.readVars("common_vars")
 	}
 	return r.commonVars
 }
@@ -104,7 +391,10 @@ func (r *Resolver) getGroupVars(group string) varmap.VarMap {
 		r.groupVars = make(map[string]varmap.VarMap)
 	}
 	if _, ok := r.groupVars[group]; !ok {
-		m := varmap.Read(filepath.Join("group_vars", group))
+		m := r.readVars(filepath.Join("group_vars", group))
+		if pluginVars := r.inv.GetPluginVars(inventory.PluginVarsGroups, group); pluginVars != nil {
+			m = varmap.Merge(m, pluginVars, varmap.ConflictResolutionError)
+		}
 		r.groupVars[group] = m
 		return m
 	}
@@ -116,7 +406,10 @@ func (r *Resolver) getHostVars(host string) varmap.VarMap {
 		r.hostVars = make(map[string]varmap.VarMap)
 	}
 	if _, ok := r.hostVars[host]; !ok {
-		m := varmap.Read(filepath.Join("host_vars", host))
+		m := r.readVars(filepath.Join("host_vars", host))
+		if pluginVars := r.inv.GetPluginVars(inventory.PluginVarsHosts, host); pluginVars != nil {
+			m = varmap.Merge(m, pluginVars, varmap.ConflictResolutionError)
+		}
 		r.hostVars[host] = m
 		return m
 
@@ -129,7 +422,7 @@ func (r *Resolver) getAppVars(app string) varmap.VarMap {
 		r.appVars = make(map[string]varmap.VarMap)
 	}
 	if _, ok := r.appVars[app]; !ok {
-		m := varmap.Read(filepath.Join("app_vars", app))
+		m := r.readVars(filepath.Join("app_vars", app))
 		r.appVars[app] = m
 		return m
 
@@ -142,7 +435,10 @@ func (r *Resolver) getInstanceVars(inst string) varmap.VarMap {
 		r.instanceVars = make(map[string]varmap.VarMap)
 	}
 	if _, ok := r.instanceVars[inst]; !ok {
-		m := varmap.Read(filepath.Join("instance_vars", inst))
+		m := r.readVars(filepath.Join("instance_vars", inst))
+		if pluginVars := r.inv.GetPluginVars(inventory.PluginVarsInstances, inst); pluginVars != nil {
+			m = varmap.Merge(m, pluginVars, varmap.ConflictResolutionError)
+		}
 		r.instanceVars[inst] = m
 		return m
 
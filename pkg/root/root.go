@@ -0,0 +1,84 @@
+package root
+
+import (
+	"golden/pkg/fsys"
+	"golden/pkg/rerrors"
+	"golden/pkg/ryaml"
+	"os"
+	"path/filepath"
+)
+
+// ConfigFile of a root may include other roots:
+//
+//	includes:
+//	  - ../platform
+//
+// Included roots are relative to the including one and have lower
+// precedence than it. Like every configuration file, it may be
+// golden.yaml or golden.json as well.
+const ConfigFile = "golden.yml"
+
+type config struct {
+	Includes []string `yaml:"includes"`
+}
+
+// Roots are directories with apps, inventories and variables layered
+// from the lowest precedence to the highest.
+type Roots []string
+
+// Read returns dirs with their includes expanded. Every root is listed
+// once, at its first occurrence.
+func Read(dirs []string) Roots {
+	rs := Roots{}
+	seen := map[string]struct{}{}
+	var add func(dir string)
+	add = func(dir string) {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			panic(err)
+		}
+		if _, ok := seen[abs]; ok {
+			return
+		}
+		seen[abs] = struct{}{}
+		if !fsys.DoesDirExists(dir) {
+			panic(rerrors.NewErrStringf("root directory %s does not exist", dir))
+		}
+		if configFile := ryaml.FindFile(filepath.Join(dir, ConfigFile)); configFile != "" {
+			cfg := config{}
+			ryaml.ReadYamlFile(configFile, &cfg)
+			for _, include := range cfg.Includes {
+				if !filepath.IsAbs(include) {
+					include = filepath.Join(dir, include)
+				}
+				add(include)
+			}
+		}
+		rs = append(rs, filepath.Clean(dir))
+	}
+	for _, dir := range dirs {
+		add(dir)
+	}
+	return rs
+}
+
+// Paths returns a path relative to a root within every root.
+func (rs Roots) Paths(path string) []string {
+	out := make([]string, len(rs))
+	for i, r := range rs {
+		out[i] = filepath.Join(r, path)
+	}
+	return out
+}
+
+// Find returns a path relative to a root within the root of the highest
+// precedence where it exists, or "" if it does not exist in any.
+func (rs Roots) Find(path string) string {
+	for i := len(rs) - 1; i >= 0; i-- {
+		p := filepath.Join(rs[i], path)
+		if _, err := os.Stat(p); err == nil {
+			return p
+		}
+	}
+	return ""
+}
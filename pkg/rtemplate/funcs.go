@@ -0,0 +1,405 @@
+package rtemplate
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"unicode"
+)
+
+// Funcs are available in file templates and in templated variables.
+// Functions taking a value take it as the last argument, so they
+// work in pipelines, e. g. {{ .port | default 80 }}.
+//
+// Templates fail on a missing key, so a variable which may be undefined
+// is looked up with index, which gives nil for a missing key:
+//
+//	{{ index . "port" | default 80 }}
+//	{{ index .server "port" | default 80 }}
+//
+// Serialization:
+//
+//	to_yaml VALUE           YAML with 2 spaces indentation
+//	to_json VALUE           compact JSON
+//	to_toml MAP             TOML, nested maps become tables
+//	to_ini MAP              INI, nested maps become sections, lists are joined with ","
+//
+// Strings:
+//
+//	indent N S              indents every line of S by N spaces
+//	nindent N S             same as indent, prepended with a newline
+//	quote S                 double quoted S with escapes, "" for nil
+//	squote S                single quoted S, ' is doubled as in YAML, '' for nil
+//	lower S, upper S, title S
+//	snake_case S, camel_case S, kebab_case S
+//	trim S
+//	replace OLD NEW S
+//	join SEP LIST
+//	split SEP S
+//	b64enc S, b64dec S
+//	sha256sum S             hex encoded sha256 of S
+//
+// Regular expressions (Go syntax):
+//
+//	regex_match RE S        whether S matches RE
+//	regex_find_all RE S     all matches of RE in S
+//	regex_replace RE REPL S replaces matches of RE, REPL may use $1
+//
+// Values:
+//
+//	default DEFAULT VALUE   VALUE unless it is empty or nil, DEFAULT otherwise
+//	required MSG VALUE      VALUE unless it is empty, fails with MSG otherwise
+//	keys MAP                sorted keys of MAP, to iterate in a stable order
+//
+// Control:
+//
+//	skip                    do not deploy the file, see ErrSkip
+//
+// Inventory, available in file templates and in variables of instances
+// only, see resolver.Funcs:
+//
+//	instances_in_group GROUP, hosts_in_group GROUP, instance_vars INSTANCE
+var Funcs = template.FuncMap{
+	"to_yaml":        ToYaml,
+	"to_json":        toJson,
+	"to_toml":        toToml,
+	"to_ini":         toIni,
+	"indent":         indent,
+	"nindent":        nindent,
+	"quote":          quote,
+	"squote":         squote,
+	"lower":          strings.ToLower,
+	"upper":          strings.ToUpper,
+	"title":          title,
+	"snake_case":     func(s string) string { return strings.Join(words(s), "_") },
+	"kebab_case":     func(s string) string { return strings.Join(words(s), "-") },
+	"camel_case":     camelCase,
+	"trim":           strings.TrimSpace,
+	"replace":        func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+	"join":           join,
+	"split":          func(sep, s string) []string { return strings.Split(s, sep) },
+	"b64enc":         func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) },
+	"b64dec":         b64dec,
+	"sha256sum":      sha256sum,
+	"regex_match":    regexMatch,
+	"regex_find_all": regexFindAll,
+	"regex_replace":  regexReplace,
+	"default":        defaultValue,
+	"required":       required,
+	"keys":           keys,
+	"skip":           Skip,
+
+	"instances_in_group": unavailable("instances_in_group"),
+	"hosts_in_group":     unavailable("hosts_in_group"),
+	"instance_vars":      unavailable("instance_vars"),
+}
+
+// unavailable is a stub of a function which is provided by another package
+// where it makes sense. Templates must still parse everywhere.
+func unavailable(name string) func(string) (interface{}, error) {
+	return func(string) (interface{}, error) {
+		return nil, fmt.Errorf("%s is not available here", name)
+	}
+}
+
+func toJson(val interface{}) (string, error) {
+	b, err := json.Marshal(val)
+	return string(b), err
+}
+
+func indent(n int, s string) string {
+	pad := strings.Repeat(" ", n)
+	return pad + strings.ReplaceAll(s, "\n", "\n"+pad)
+}
+
+func nindent(n int, s string) string {
+	return "\n" + indent(n, s)
+}
+
+func quote(val interface{}) string {
+	return strconv.Quote(toString(val))
+}
+
+func squote(val interface{}) string {
+	return "'" + strings.ReplaceAll(toString(val), "'", "''") + "'"
+}
+
+// toString formats a value, nil is empty rather than "<nil>".
+func toString(val interface{}) string {
+	if val == nil {
+		return ""
+	}
+	return fmt.Sprint(val)
+}
+
+// words splits a string into lower case words on separators and
+// on lower to upper case transitions.
+func words(s string) []string {
+	out := []string{}
+	cur := []rune{}
+	flush := func() {
+		if len(cur) != 0 {
+			out = append(out, strings.ToLower(string(cur)))
+			cur = cur[:0]
+		}
+	}
+	runes := []rune(s)
+	for i, r := range runes {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			flush()
+			continue
+		}
+		if unicode.IsUpper(r) && i > 0 && len(cur) != 0 {
+			prev := runes[i-1]
+			nextIsLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextIsLower) {
+				flush()
+			}
+		}
+		cur = append(cur, r)
+	}
+	flush()
+	return out
+}
+
+func title(s string) string {
+	out := []rune(s)
+	start := true
+	for i, r := range out {
+		if start {
+			out[i] = unicode.ToUpper(r)
+		}
+		start = unicode.IsSpace(r)
+	}
+	return string(out)
+}
+
+func camelCase(s string) string {
+	ws := words(s)
+	for i := 1; i < len(ws); i++ {
+		ws[i] = title(ws[i])
+	}
+	return strings.Join(ws, "")
+}
+
+func join(sep string, list interface{}) (string, error) {
+	v := reflect.ValueOf(list)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return "", fmt.Errorf("join: expected a list, got %T", list)
+	}
+	parts := make([]string, v.Len())
+	for i := range parts {
+		parts[i] = fmt.Sprint(v.Index(i).Interface())
+	}
+	return strings.Join(parts, sep), nil
+}
+
+func b64dec(s string) (string, error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	return string(b), err
+}
+
+func sha256sum(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func regexMatch(re, s string) (bool, error) {
+	return regexp.MatchString(re, s)
+}
+
+func regexFindAll(re, s string) ([]string, error) {
+	compiled, err := regexp.Compile(re)
+	if err != nil {
+		return nil, err
+	}
+	return compiled.FindAllString(s, -1), nil
+}
+
+func regexReplace(re, repl, s string) (string, error) {
+	compiled, err := regexp.Compile(re)
+	if err != nil {
+		return "", err
+	}
+	return compiled.ReplaceAllString(s, repl), nil
+}
+
+func isEmpty(val interface{}) bool {
+	if val == nil {
+		return true
+	}
+	v := reflect.ValueOf(val)
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return v.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	}
+	return v.IsZero()
+}
+
+func defaultValue(def interface{}, val ...interface{}) interface{} {
+	if len(val) == 0 || isEmpty(val[0]) {
+		return def
+	}
+	return val[0]
+}
+
+func required(msg string, val ...interface{}) (interface{}, error) {
+	if len(val) == 0 || isEmpty(val[0]) {
+		return nil, fmt.Errorf("%s", msg)
+	}
+	return val[0], nil
+}
+
+func keys(m interface{}) ([]string, error) {
+	v := reflect.ValueOf(m)
+	if v.Kind() != reflect.Map {
+		return nil, fmt.Errorf("keys: expected a map, got %T", m)
+	}
+	out := make([]string, 0, v.Len())
+	for _, k := range v.MapKeys() {
+		out = append(out, fmt.Sprint(k.Interface()))
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+func toToml(val interface{}) (string, error) {
+	m, ok := val.(map[string]interface{})
+	if !ok {
+		return "", fmt.Errorf("to_toml: expected a map, got %T", val)
+	}
+	b := strings.Builder{}
+	if err := writeTomlTable(&b, nil, m); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+func writeTomlTable(b *strings.Builder, path []string, m map[string]interface{}) error {
+	tables := []string{}
+	for _, k := range sortedKeys(m) {
+		if _, isTable := m[k].(map[string]interface{}); isTable {
+			tables = append(tables, k)
+			continue
+		}
+		s, err := tomlValue(m[k])
+		if err != nil {
+			return fmt.Errorf("%s: %s", strings.Join(append(path, k), "."), err)
+		}
+		fmt.Fprintf(b, "%s = %s\n", tomlKey(k), s)
+	}
+	for _, k := range tables {
+		tablePath := append(append([]string{}, path...), k)
+		quoted := make([]string, len(tablePath))
+		for i, p := range tablePath {
+			quoted[i] = tomlKey(p)
+		}
+		if b.Len() != 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(b, "[%s]\n", strings.Join(quoted, "."))
+		if err := writeTomlTable(b, tablePath, m[k].(map[string]interface{})); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var bareTomlKey = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+func tomlKey(k string) string {
+	if bareTomlKey.MatchString(k) {
+		return k
+	}
+	return strconv.Quote(k)
+}
+
+func tomlValue(val interface{}) (string, error) {
+	switch v := val.(type) {
+	case nil:
+		return "", fmt.Errorf("null values are not supported by TOML")
+	case string:
+		return strconv.Quote(v), nil
+	case bool, int, int64, uint64, float64:
+		return fmt.Sprint(v), nil
+	case []interface{}:
+		parts := make([]string, len(v))
+		for i, el := range v {
+			s, err := tomlValue(el)
+			if err != nil {
+				return "", err
+			}
+			parts[i] = s
+		}
+		return "[" + strings.Join(parts, ", ") + "]", nil
+	case map[string]interface{}:
+		parts := make([]string, 0, len(v))
+		for _, k := range sortedKeys(v) {
+			s, err := tomlValue(v[k])
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, tomlKey(k)+" = "+s)
+		}
+		return "{ " + strings.Join(parts, ", ") + " }", nil
+	}
+	return "", fmt.Errorf("unsupported value of type %T", val)
+}
+
+func toIni(val interface{}) (string, error) {
+	m, ok := val.(map[string]interface{})
+	if !ok {
+		return "", fmt.Errorf("to_ini: expected a map, got %T", val)
+	}
+	b := strings.Builder{}
+	sections := []string{}
+	for _, k := range sortedKeys(m) {
+		if _, isSection := m[k].(map[string]interface{}); isSection {
+			sections = append(sections, k)
+			continue
+		}
+		fmt.Fprintf(&b, "%s = %s\n", k, iniValue(m[k]))
+	}
+	for _, section := range sections {
+		if b.Len() != 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "[%s]\n", section)
+		sm := m[section].(map[string]interface{})
+		for _, k := range sortedKeys(sm) {
+			if _, isMap := sm[k].(map[string]interface{}); isMap {
+				return "", fmt.Errorf("to_ini: %s.%s: sections can not be nested", section, k)
+			}
+			fmt.Fprintf(&b, "%s = %s\n", k, iniValue(sm[k]))
+		}
+	}
+	return b.String(), nil
+}
+
+// iniValue joins lists with commas as INI has no lists.
+func iniValue(val interface{}) string {
+	if list, ok := val.([]interface{}); ok {
+		s, _ := join(",", list)
+		return s
+	}
+	return fmt.Sprint(val)
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	out := make([]string, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
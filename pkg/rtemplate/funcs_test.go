@@ -0,0 +1,126 @@
+package rtemplate
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFuncs(t *testing.T) {
+	vars := map[string]interface{}{
+		"empty":  "",
+		"nil":    nil,
+		"port":   8080,
+		"name":   "web",
+		"quoted": `it's "x"`,
+		"list":   []interface{}{"a", 1, true},
+		"server": map[string]interface{}{"host": "h1", "port": 80},
+		"tree": map[string]interface{}{
+			"a":    1,
+			"list": []interface{}{1, "two"},
+			"sec":  map[string]interface{}{"k": "v w"},
+		},
+	}
+	for _, tc := range []struct {
+		name, tmpl, want string
+	}{
+		{"to_yaml", `{{ to_yaml .server }}`, "host: h1\nport: 80\n"},
+		{"to_yaml list", `{{ to_yaml .tree.list }}`, "- 1\n- two\n"},
+		{"to_json", `{{ to_json .server }}`, `{"host":"h1","port":80}`},
+		{"to_json list", `{{ to_json .list }}`, `["a",1,true]`},
+		{"to_toml", `{{ to_toml .tree }}`, "a = 1\nlist = [1, \"two\"]\n\n[sec]\nk = \"v w\"\n"},
+		{"to_toml quoted key", `{{ to_toml (dict "a b" 1) }}`, "\"a b\" = 1\n"},
+		{"to_ini", `{{ to_ini .tree }}`, "a = 1\nlist = 1,two\n\n[sec]\nk = v w\n"},
+		{"indent", `{{ indent 2 "a\nb" }}`, "  a\n  b"},
+		{"nindent", `{{ nindent 2 "a\nb" }}`, "\n  a\n  b"},
+		{"quote", `{{ quote .quoted }}`, `"it's \"x\""`},
+		{"quote number", `{{ quote .port }}`, `"8080"`},
+		{"quote nil", `{{ quote .nil }}`, `""`},
+		{"squote", `{{ squote .quoted }}`, `'it''s "x"'`},
+		{"squote nil", `{{ squote .nil }}`, `''`},
+		{"lower", `{{ lower "AbC" }}`, "abc"},
+		{"upper", `{{ upper "AbC" }}`, "ABC"},
+		{"title", `{{ title "hello  big world" }}`, "Hello  Big World"},
+		{"snake_case", `{{ snake_case "HTTPServerName" }}`, "http_server_name"},
+		{"snake_case separators", `{{ snake_case "foo-bar baz" }}`, "foo_bar_baz"},
+		{"kebab_case", `{{ kebab_case "maxConn2Pool" }}`, "max-conn2-pool"},
+		{"camel_case", `{{ camel_case "foo_bar-baz" }}`, "fooBarBaz"},
+		{"trim", `{{ trim "  a b \n" }}`, "a b"},
+		{"replace", `{{ replace "a" "b" "banana" }}`, "bbnbnb"},
+		{"join", `{{ join ", " .list }}`, "a, 1, true"},
+		{"split", `{{ range split "," "a,b" }}[{{ . }}]{{ end }}`, "[a][b]"},
+		{"b64enc", `{{ b64enc "hi" }}`, "aGk="},
+		{"b64dec", `{{ b64dec "aGk=" }}`, "hi"},
+		{"sha256sum", `{{ sha256sum "abc" }}`, "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+		{"regex_match", `{{ regex_match "^w.b$" .name }} {{ regex_match "^x" .name }}`, "true false"},
+		{"regex_find_all", `{{ regex_find_all "[0-9]+" "a1b22c333" }}`, "[1 22 333]"},
+		{"regex_replace", `{{ regex_replace "(\\w+)@(\\w+)" "$2 at $1" "me@home" }}`, "home at me"},
+		{"default of empty", `{{ .empty | default "x" }}`, "x"},
+		{"default of nil", `{{ .nil | default "x" }}`, "x"},
+		{"default of zero", `{{ 0 | default 5 }}`, "5"},
+		{"default of value", `{{ .port | default 80 }}`, "8080"},
+		{"default of missing key", `{{ index . "missing" | default 80 }}`, "80"},
+		{"default of missing nested key", `{{ index .server "missing" | default 80 }}`, "80"},
+		{"required", `{{ .name | required "name is required" }}`, "web"},
+		{"keys", `{{ keys .server }}`, "[host port]"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := execute(tc.tmpl, vars)
+			if err != nil {
+				t.Fatalf("%s: %s", tc.tmpl, err)
+			}
+			if got != tc.want {
+				t.Errorf("%s = %q, want %q", tc.tmpl, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestFuncsErrors(t *testing.T) {
+	vars := map[string]interface{}{"empty": "", "name": "web"}
+	for _, tc := range []struct {
+		name, tmpl, want string
+	}{
+		{"required", `{{ .empty | required "name is required" }}`, "name is required"},
+		{"join of a string", `{{ join "," .name }}`, "expected a list"},
+		{"keys of a string", `{{ keys .name }}`, "expected a map"},
+		{"to_toml of a string", `{{ to_toml .name }}`, "expected a map"},
+		{"to_ini of a string", `{{ to_ini .name }}`, "expected a map"},
+		{"to_ini nested", `{{ to_ini (dict "s" (dict "t" (dict "k" 1))) }}`, "can not be nested"},
+		{"b64dec", `{{ b64dec "!" }}`, "illegal base64"},
+		{"regex_match", `{{ regex_match "(" .name }}`, "missing closing )"},
+		{"missing key", `{{ .missing | default 80 }}`, `map has no entry for key "missing"`},
+		{"unavailable", `{{ instances_in_group "web" }}`, "instances_in_group is not available here"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := execute(tc.tmpl, vars)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("%s: got %v, want an error containing %q", tc.tmpl, err, tc.want)
+			}
+		})
+	}
+}
+
+func TestSkip(t *testing.T) {
+	_, err := execute(`a{{ skip }}`, nil)
+	if !IsSkipped(err) {
+		t.Errorf("got %v, want ErrSkip", err)
+	}
+}
+
+// execute runs a template the way files are rendered. dict builds
+// a map of key value pairs for inputs which can not be written literally.
+func execute(text string, vars map[string]interface{}) (string, error) {
+	t, err := New("test").Funcs(map[string]interface{}{
+		"dict": func(kv ...interface{}) map[string]interface{} {
+			m := map[string]interface{}{}
+			for i := 0; i+1 < len(kv); i += 2 {
+				m[kv[i].(string)] = kv[i+1]
+			}
+			return m
+		},
+	}).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	return ExecToString(t, vars)
+}
@@ -0,0 +1,127 @@
+package rtemplate
+
+import (
+	"sort"
+	"strings"
+	"text/template/parse"
+)
+
+// References returns sorted dotted paths of variables a template refers
+// to, e. g. "server.port" for {{ .server.port }} or {{ $.server.port }}.
+// Fields within range and with refer to their elements, not to variables,
+// and are left out unless referred to through $.
+//
+// Partials included with {{ template }} are looked up by name in partials
+// and their references are relative to the data passed to them, e. g.
+// "tls.cert" for {{ .cert }} of a partial included with
+// {{ template "tls_block" .tls }}.
+func References(tree *parse.Tree, partials map[string]*parse.Tree) []string {
+	c := &refCollector{refs: map[string]struct{}{}, partials: partials, including: map[string]bool{}}
+	if tree != nil && tree.Root != nil {
+		c.collect(tree.Root, []string{}, []string{})
+	}
+	out := make([]string, 0, len(c.refs))
+	for ref := range c.refs {
+		out = append(out, ref)
+	}
+	sort.Strings(out)
+	return out
+}
+
+type refCollector struct {
+	refs      map[string]struct{}
+	partials  map[string]*parse.Tree
+	including map[string]bool
+}
+
+// collect collects references of node. dot and root are paths of the
+// variables . and $ refer to, nil if they do not refer to variables.
+func (c *refCollector) collect(node parse.Node, dot, root []string) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			c.collect(child, dot, root)
+		}
+	case *parse.ActionNode:
+		c.collect(n.Pipe, dot, root)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			c.collect(cmd, dot, root)
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			c.collect(arg, dot, root)
+		}
+	case *parse.FieldNode:
+		c.add(dot, n.Ident)
+	case *parse.VariableNode:
+		if len(n.Ident) > 1 && n.Ident[0] == "$" {
+			c.add(root, n.Ident[1:])
+		}
+	case *parse.ChainNode:
+		c.collect(n.Node, dot, root)
+	case *parse.IfNode:
+		c.collect(n.Pipe, dot, root)
+		c.collect(n.List, dot, root)
+		c.collect(n.ElseList, dot, root)
+	case *parse.RangeNode:
+		c.collect(n.Pipe, dot, root)
+		c.collect(n.List, nil, root)
+		c.collect(n.ElseList, dot, root)
+	case *parse.WithNode:
+		c.collect(n.Pipe, dot, root)
+		c.collect(n.List, nil, root)
+		c.collect(n.ElseList, dot, root)
+	case *parse.TemplateNode:
+		c.collect(n.Pipe, dot, root)
+		partial, ok := c.partials[n.Name]
+		if !ok || partial.Root == nil || c.including[n.Name] {
+			return
+		}
+		arg := c.argPath(n.Pipe, dot, root)
+		if arg == nil {
+			return
+		}
+		c.including[n.Name] = true
+		c.collect(partial.Root, arg, arg)
+		delete(c.including, n.Name)
+	}
+}
+
+func (c *refCollector) add(base, ident []string) {
+	if base == nil {
+		return
+	}
+	c.refs[strings.Join(append(append([]string{}, base...), ident...), ".")] = struct{}{}
+}
+
+// argPath returns the path of a variable passed to a partial, nil if
+// anything else is passed.
+func (c *refCollector) argPath(pipe *parse.PipeNode, dot, root []string) []string {
+	if pipe == nil || len(pipe.Decl) != 0 || len(pipe.Cmds) != 1 || len(pipe.Cmds[0].Args) != 1 {
+		return nil
+	}
+	join := func(base, ident []string) []string {
+		if base == nil {
+			return nil
+		}
+		return append(append([]string{}, base...), ident...)
+	}
+	switch arg := pipe.Cmds[0].Args[0].(type) {
+	case *parse.DotNode:
+		return dot
+	case *parse.FieldNode:
+		return join(dot, arg.Ident)
+	case *parse.VariableNode:
+		if arg.Ident[0] == "$" {
+			return join(root, arg.Ident[1:])
+		}
+	}
+	return nil
+}
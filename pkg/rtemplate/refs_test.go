@@ -0,0 +1,46 @@
+package rtemplate
+
+import (
+	"reflect"
+	"testing"
+	"text/template/parse"
+)
+
+func TestReferences(t *testing.T) {
+	partials := `{{ define "tls" }}{{ .cert }} {{ $.key }}{{ end }}` +
+		`{{ define "nested" }}{{ template "tls" .tls }}{{ end }}` +
+		`{{ define "loop" }}{{ .a }}{{ template "loop" .b }}{{ end }}`
+	for _, tc := range []struct {
+		tmpl string
+		want []string
+	}{
+		{`{{ .server.port }} {{ $.name }}`, []string{"name", "server.port"}},
+		{`{{ if .a }}{{ .b }}{{ else }}{{ .c }}{{ end }}`, []string{"a", "b", "c"}},
+		{`{{ range .list }}{{ .x }}{{ $.y }}{{ end }}`, []string{"list", "y"}},
+		{`{{ with .server }}{{ .port }}{{ else }}{{ .z }}{{ end }}`, []string{"server", "z"}},
+		{`{{ .name | default .other }}`, []string{"name", "other"}},
+		{`{{ template "tls" . }}`, []string{"cert", "key"}},
+		{`{{ template "tls" .site.tls }}`, []string{"site.tls", "site.tls.cert", "site.tls.key"}},
+		{`{{ template "tls" $.site }}`, []string{"site", "site.cert", "site.key"}},
+		{`{{ template "nested" .site }}`, []string{"site", "site.tls", "site.tls.cert", "site.tls.key"}},
+		{`{{ range .sites }}{{ template "tls" . }}{{ end }}`, []string{"sites"}},
+		{`{{ template "tls" (index . "site") }}`, []string{}},
+		{`{{ template "tls" }}`, []string{}},
+		{`{{ template "unknown" .x }}`, []string{"x"}},
+		{`{{ template "loop" .r }}`, []string{"r", "r.a", "r.b"}},
+	} {
+		t.Run(tc.tmpl, func(t *testing.T) {
+			tmpl, err := New("test").Parse(partials + tc.tmpl)
+			if err != nil {
+				t.Fatal(err)
+			}
+			trees := map[string]*parse.Tree{}
+			for _, defined := range tmpl.Templates() {
+				trees[defined.Name()] = defined.Tree
+			}
+			if got := References(tmpl.Tree, trees); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package rtemplate
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 	"text/template"
@@ -54,10 +55,22 @@ func ExecToString(t *template.Template, dot interface{}) (string, error) {
 	return b.String(), err
 }
 
+// New returns a template with Funcs registered.
 func New(name string) *template.Template {
-	return template.New(name).Funcs(template.FuncMap{
-		"to_yaml": ToYaml,
-	})
+	return template.New(name).Funcs(Funcs)
+}
+
+// ErrSkip is returned by a template calling skip. A file whose template
+// or name calls skip is not deployed.
+var ErrSkip = errors.New("skipped")
+
+var Skip = func() (string, error) {
+	return "", ErrSkip
+}
+
+// IsSkipped reports whether an execution error came from skip.
+func IsSkipped(err error) bool {
+	return errors.Is(err, ErrSkip)
 }
 
 var ToYaml = func(val interface{}) (string, error) {
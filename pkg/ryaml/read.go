@@ -1,9 +1,14 @@
 package ryaml
 
 import (
+	"errors"
+	"fmt"
 	"golden/pkg/fsys"
 	"golden/pkg/rerrors"
+	"golden/pkg/secrets"
 	"os"
+	"regexp"
+	"strconv"
 	"strings"
 
 	"gopkg.in/yaml.v3"
@@ -14,55 +19,192 @@ func ReadYamlFile(filename string, out interface{}) {
 	if err != nil {
 		panic(rerrors.NewErrIo(filename, "reading yaml", err))
 	}
+	if secrets.IsEncrypted(data) {
+		data = []byte(secrets.MustDecrypt(string(data), filename))
+	}
 	if unm, ok := out.(Unmarshaller); ok {
 		err = unm.CustomUnmarshallYAML(data)
 	} else {
 		err = yaml.Unmarshal(data, out)
 	}
 	if err != nil {
-		panic(rerrors.NewErrIo(filename, "parsing yaml", err))
+		panic(parseErrors(filename, err))
 	}
 }
 
-func ReadYamlRecursive(fileBaseNameOrDir string, placeholderGenerator func(filename string) interface{}) []interface{} {
-	var filename string
-	var dirname string
-	if !strings.HasSuffix(fileBaseNameOrDir, ".yml") {
-		filename = fileBaseNameOrDir + ".yml"
-		dirname = fileBaseNameOrDir
-	} else {
-		filename = fileBaseNameOrDir
-		dirname = filename[0 : len(filename)-len(".yml")]
+var (
+	typeErrorRegexp   = regexp.MustCompile(`^line (\d+)(?:, column (\d+))?: (.*)$`)
+	syntaxErrorRegexp = regexp.MustCompile(`^yaml: line (\d+): (.*)$`)
+)
+
+// parseErrors turns an error of parsing a yaml file into errors
+// with positions within the file.
+func parseErrors(filename string, err error) rerrors.Errors {
+	var at *rerrors.ErrAt
+	if errors.As(err, &at) {
+		at.Pos.File = filename
+		return rerrors.Errors{at}
+	}
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) {
+		out := make(rerrors.Errors, 0, len(typeErr.Errors))
+		for _, msg := range typeErr.Errors {
+			out = append(out, errAt(filename, typeErrorRegexp, msg))
+		}
+		return out
 	}
+	return rerrors.Errors{errAt(filename, syntaxErrorRegexp, err.Error())}
+}
 
-	dirExists := fsys.DoesDirExists(dirname)
-	fileExists := fsys.DoesFileExists(filename)
+func errAt(filename string, re *regexp.Regexp, msg string) *rerrors.ErrAt {
+	pos := rerrors.Pos{File: filename}
+	m := re.FindStringSubmatch(msg)
+	if m == nil {
+		return &rerrors.ErrAt{Pos: pos, Msg: msg}
+	}
+	pos.Line, _ = strconv.Atoi(m[1])
+	if len(m) == 4 {
+		pos.Col, _ = strconv.Atoi(m[2])
+	}
+	return &rerrors.ErrAt{Pos: pos, Msg: m[len(m)-1]}
+}
 
-	if dirExists && fileExists {
-		panic(rerrors.NewErrStringf("ambiguous %s OR %s", dirname, filename))
+// DecodeMapping calls decode for every key and value of a mapping node.
+// Keys defined twice are errors, as they are for yaml.Unmarshal.
+// Errors of NodeError of all entries are returned together, any other
+// error stops decoding.
+func DecodeMapping(node *yaml.Node, decode func(key, value *yaml.Node) error) error {
+	if node.Kind != yaml.MappingNode {
+		return NodeError(node, "expected a map")
 	}
+	var typeErrs []string
+	seen := map[string]*yaml.Node{}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key := node.Content[i]
+		if prev, ok := seen[key.Value]; ok {
+			err := NodeError(key, "mapping key %q already defined at line %d", key.Value, prev.Line)
+			typeErrs = append(typeErrs, err.(*yaml.TypeError).Errors...)
+			continue
+		}
+		seen[key.Value] = key
+		err := decode(key, node.Content[i+1])
+		var typeErr *yaml.TypeError
+		if errors.As(err, &typeErr) {
+			typeErrs = append(typeErrs, typeErr.Errors...)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if len(typeErrs) != 0 {
+		return &yaml.TypeError{Errors: typeErrs}
+	}
+	return nil
+}
 
-	if !dirExists && !fileExists {
-		return nil
+// NodeError is an error at a node to be returned from UnmarshalYAML.
+// Unlike other errors, it does not stop decoding of the rest of a file,
+// so all errors of the file are reported.
+func NodeError(node *yaml.Node, format string, args ...interface{}) error {
+	return &yaml.TypeError{Errors: []string{
+		fmt.Sprintf("line %d, column %d: %s", node.Line, node.Column, fmt.Sprintf(format, args...)),
+	}}
+}
+
+// Extensions of configuration files. JSON is read as YAML.
+var Extensions = []string{".yml", ".yaml", ".json"}
+
+// HasExtension reports whether a file is a configuration file.
+func HasExtension(filename string) bool {
+	return trimExtension(filename) != filename
+}
+
+func trimExtension(filename string) string {
+	for _, ext := range Extensions {
+		if strings.HasSuffix(filename, ext) {
+			return strings.TrimSuffix(filename, ext)
+		}
+	}
+	return filename
+}
+
+// FindFile returns an existing configuration file with any of Extensions
+// named as filename, e. g. golden.yaml for golden.yml, or "" if there
+// is none. It panics if there are several.
+func FindFile(filename string) string {
+	found := existingFiles(trimExtension(filename))
+	if len(found) > 1 {
+		panic(rerrors.NewErrStringf("ambiguous %s", strings.Join(found, " OR ")))
+	}
+	if len(found) == 0 {
+		return ""
+	}
+	return found[0]
+}
+
+func existingFiles(base string) []string {
+	found := []string{}
+	for _, ext := range Extensions {
+		if fsys.DoesFileExists(base + ext) {
+			found = append(found, base+ext)
+		}
 	}
+	return found
+}
+
+// ReadYamlRecursive reads fileBaseNameOrDir with any of Extensions or every
+// such file within fileBaseNameOrDir in sorted order into placeholders.
+// It reports errors of all files at once.
+func ReadYamlRecursive(fileBaseNameOrDir string, placeholderGenerator func(filename string) interface{}) []interface{} {
+	errs := &rerrors.Collector{}
+	out := CollectYamlRecursive(fileBaseNameOrDir, placeholderGenerator, errs)
+	errs.PanicIfAny()
+	return out
+}
 
+// CollectYamlRecursive is ReadYamlRecursive adding errors to errs instead.
+// Placeholders of files with errors are returned too, partially read,
+// so that callers keep finding errors, e. g. duplicates, in the rest.
+func CollectYamlRecursive(fileBaseNameOrDir string, placeholderGenerator func(filename string) interface{}, errs *rerrors.Collector) []interface{} {
+	dirname := trimExtension(fileBaseNameOrDir)
+	files := existingFiles(dirname)
 	if fsys.DoesDirExists(dirname) {
+		files = append([]string{dirname}, files...)
+	}
+	if len(files) > 1 {
+		errs.Add(rerrors.NewErrStringf("ambiguous %s", strings.Join(files, " OR ")))
+		return nil
+	}
+	if len(files) == 0 {
+		return nil
+	}
+
+	if files[0] == dirname {
 		allFiles, err := fsys.GetAllFilesRecursive(dirname)
 		if err != nil {
-			panic(err)
+			errs.Add(rerrors.NewErrIo(dirname, "listing yaml files", err))
+			return nil
 		}
 		out := make([]interface{}, 0, len(allFiles))
+		seen := map[string]string{}
 		for _, file := range allFiles {
-			if strings.HasSuffix(file, ".yml") {
-				ph := placeholderGenerator(file)
-				ReadYamlFile(file, ph)
-				out = append(out, ph)
+			if !HasExtension(file) {
+				continue
+			}
+			if other, ok := seen[trimExtension(file)]; ok {
+				errs.Add(rerrors.NewErrStringf("ambiguous %s OR %s", other, file))
+				continue
 			}
+			seen[trimExtension(file)] = file
+			ph := placeholderGenerator(file)
+			errs.Catch(func() { ReadYamlFile(file, ph) })
+			out = append(out, ph)
 		}
 		return out
 	}
-	ph := placeholderGenerator(filename)
-	ReadYamlFile(filename, ph)
+	ph := placeholderGenerator(files[0])
+	errs.Catch(func() { ReadYamlFile(files[0], ph) })
 	return []interface{}{ph}
 }
 
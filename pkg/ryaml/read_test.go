@@ -0,0 +1,94 @@
+package ryaml
+
+import (
+	"golden/pkg/rerrors/rerrorstest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadYamlRecursive(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		files map[string]string
+		want  []map[string]interface{}
+	}{
+		{"yml", map[string]string{"vars.yml": "a: 1\n"}, []map[string]interface{}{{"a": 1}}},
+		{"yaml", map[string]string{"vars.yaml": "a: 1\n"}, []map[string]interface{}{{"a": 1}}},
+		{"json", map[string]string{"vars.json": `{"a": 1, "b": [true]}`}, []map[string]interface{}{{"a": 1, "b": []interface{}{true}}}},
+		{
+			"directory in sorted order, other extensions skipped",
+			map[string]string{"vars/b.json": `{"b": 2}`, "vars/a.yml": "a: 1\n", "vars/sub/c.yaml": "c: 3\n", "vars/README": "d: 4\n"},
+			[]map[string]interface{}{{"a": 1}, {"b": 2}, {"c": 3}},
+		},
+		{"missing", map[string]string{"other.yml": "a: 1\n"}, []map[string]interface{}{}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := writeFiles(t, tc.files)
+			got := []map[string]interface{}{}
+			for _, m := range ReadYamlRecursive(filepath.Join(dir, "vars"), newMap) {
+				got = append(got, *m.(*map[string]interface{}))
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestReadYamlRecursiveAmbiguity(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		files []string
+		want  string
+	}{
+		{"yml and yaml", []string{"vars.yml", "vars.yaml"}, "ambiguous {dir}/vars.yml OR {dir}/vars.yaml"},
+		{"yml and json", []string{"vars.yml", "vars.json"}, "ambiguous {dir}/vars.yml OR {dir}/vars.json"},
+		{"file and directory", []string{"vars.json", "vars/a.yml"}, "ambiguous {dir}/vars OR {dir}/vars.json"},
+		{"within a directory", []string{"vars/a.yml", "vars/a.json"}, "ambiguous {dir}/vars/a.json OR {dir}/vars/a.yml"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			files := map[string]string{}
+			for _, f := range tc.files {
+				files[f] = "a: 1\n"
+			}
+			dir := writeFiles(t, files)
+			want := replaceDir(tc.want, dir)
+			if err := rerrorstest.Message(func() { ReadYamlRecursive(filepath.Join(dir, "vars"), newMap) }); err != want {
+				t.Errorf("got %q, want %q", err, want)
+			}
+		})
+	}
+}
+
+func TestFindFileAmbiguity(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"golden.yaml": "", "golden.json": ""})
+	want := replaceDir("ambiguous {dir}/golden.yaml OR {dir}/golden.json", dir)
+	if err := rerrorstest.Message(func() { FindFile(filepath.Join(dir, "golden.yml")) }); err != want {
+		t.Errorf("got %q, want %q", err, want)
+	}
+}
+
+func newMap(string) interface{} {
+	return &map[string]interface{}{}
+}
+
+func replaceDir(s, dir string) string {
+	return filepath.FromSlash(strings.ReplaceAll(s, "{dir}", dir))
+}
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for file, content := range files {
+		path := filepath.Join(dir, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
@@ -0,0 +1,409 @@
+package schema
+
+import (
+	"bytes"
+	"fmt"
+	"golden/pkg/apps"
+	"golden/pkg/rerrors"
+	"golden/pkg/root"
+	"golden/pkg/ryaml"
+	"io"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FileName within apps.MetaDir describes variables an app expects.
+// Keys are paths of variables, "*" matches every key of a map or
+// every element of a list:
+//
+//	port:
+//	  type: int
+//	  required: true
+//	  min: 1
+//	  max: 65535
+//	log_level:
+//	  enum: [debug, info, warn, error]
+//	vhosts.*.server_name:
+//	  type: string
+//	  required: true
+//	  pattern: '^[a-z0-9.-]+$'
+//
+// Templated variables are strings once substituted, so a string is
+// a valid int, float or bool if it parses as one.
+// Rules of an app override rules of apps it is composed of.
+const FileName = "schema.yml"
+
+// Types of variables.
+const (
+	TypeAny    = "any"
+	TypeString = "string"
+	TypeInt    = "int"
+	TypeFloat  = "float"
+	TypeBool   = "bool"
+	TypeList   = "list"
+	TypeMap    = "map"
+)
+
+type Rule struct {
+	Type     string        `yaml:"type"`
+	Required bool          `yaml:"required"`
+	Enum     []interface{} `yaml:"enum"`
+	// Min and Max bound values of numbers and lengths of strings and lists.
+	// Strings are numbers if Type is int or float.
+	Min         *float64 `yaml:"min"`
+	Max         *float64 `yaml:"max"`
+	Pattern     string   `yaml:"pattern"`
+	Description string   `yaml:"description"`
+
+	pattern *regexp.Regexp
+	source  string
+}
+
+// Schema maps paths of variables to their rules.
+type Schema map[string]*Rule
+
+func (s *Schema) CustomUnmarshallYAML(data []byte) error {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	err := dec.Decode((*map[string]*Rule)(s))
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+// Read reads a schema file and checks its rules.
+func Read(filename string) Schema {
+	s := Schema{}
+	ryaml.ReadYamlFile(filename, &s)
+	for path, rule := range s {
+		if rule == nil {
+			rule = &Rule{}
+			s[path] = rule
+		}
+		rule.source = filename
+		switch rule.Type {
+		case "":
+			rule.Type = TypeAny
+		case TypeAny, TypeString, TypeInt, TypeFloat, TypeBool, TypeList, TypeMap:
+		default:
+			panic(rerrors.NewErrStringf("%s: %s: unknown type %q", filename, path, rule.Type))
+		}
+		if rule.Pattern != "" {
+			re, err := regexp.Compile(rule.Pattern)
+			if err != nil {
+				panic(rerrors.NewErrStringf("%s: %s: invalid pattern: %s", filename, path, err))
+			}
+			rule.pattern = re
+		}
+	}
+	return s
+}
+
+// ForApp returns the schema of an app and of apps it is composed of.
+// An app without a schema has an empty one.
+func ForApp(rs root.Roots, app string) Schema {
+	s := Schema{}
+	if !apps.Exists(rs, app) {
+		return s
+	}
+	for _, layer := range apps.Layers(rs, app) {
+		filename := ryaml.FindFile(filepath.Join(layer.Dir, apps.MetaDir, FileName))
+		if filename == "" {
+			continue
+		}
+		for path, rule := range Read(filename) {
+			s[path] = rule
+		}
+	}
+	return s
+}
+
+// Validate checks resolved variables against the schema. sourceOf
+// returns a file defining a variable of a path, to be cited in problems.
+// Values of variables isSecret reports are redacted in problems.
+func (s Schema) Validate(vars map[string]interface{}, sourceOf func(path string) string, isSecret func(path string) bool) []string {
+	paths := make([]string, 0, len(s))
+	for path := range s {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	problems := []string{}
+	for _, path := range paths {
+		rule := s[path]
+		found, missing := lookup(vars, nil, strings.Split(path, "."))
+		if rule.Required {
+			for _, m := range missing {
+				problems = append(problems, missingProblem(vars, m, rule, sourceOf))
+			}
+		}
+		for _, f := range found {
+			p := strings.Join(f.path, ".")
+			if problem := rule.check(f.value, isSecret(p)); problem != "" {
+				problems = append(problems, fmt.Sprintf("%s: %s, defined in %s", p, problem, sourceOf(p)))
+			}
+		}
+	}
+	return problems
+}
+
+type foundValue struct {
+	path  []string
+	value interface{}
+}
+
+// lookup returns values of a path with wildcards and paths
+// which do not exist.
+func lookup(val interface{}, prefix, path []string) (found []foundValue, missing [][]string) {
+	if len(path) == 0 {
+		return []foundValue{{prefix, val}}, nil
+	}
+	el := path[0]
+	children := map[string]interface{}{}
+	switch v := val.(type) {
+	case map[string]interface{}:
+		if el == "*" {
+			children = v
+		} else if child, ok := v[el]; ok {
+			children[el] = child
+		}
+	case []interface{}:
+		if el == "*" {
+			for i, child := range v {
+				children[strconv.Itoa(i)] = child
+			}
+		} else if i, err := strconv.Atoi(el); err == nil && i >= 0 && i < len(v) {
+			children[el] = v[i]
+		}
+	}
+	if len(children) == 0 && el != "*" {
+		return nil, [][]string{append(append([]string{}, prefix...), path...)}
+	}
+	keys := make([]string, 0, len(children))
+	for k := range children {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		f, m := lookup(children[k], append(append([]string{}, prefix...), k), path[1:])
+		found = append(found, f...)
+		missing = append(missing, m...)
+	}
+	return found, missing
+}
+
+// missingProblem reports a missing variable suggesting a similarly named
+// one defined next to it, which is likely a typo.
+func missingProblem(vars map[string]interface{}, path []string, rule *Rule, sourceOf func(string) string) string {
+	problem := fmt.Sprintf("%s: is required by %s", strings.Join(path, "."), rule.source)
+	parent, _ := lookup(vars, nil, path[:len(path)-1])
+	if len(parent) != 1 {
+		return problem
+	}
+	siblings, ok := parent[0].value.(map[string]interface{})
+	if !ok {
+		return problem
+	}
+	name := path[len(path)-1]
+	candidates := []string{}
+	for k := range siblings {
+		if distance(k, name) <= 2 {
+			candidates = append(candidates, k)
+		}
+	}
+	if len(candidates) == 0 {
+		return problem
+	}
+	sort.Strings(candidates)
+	p := strings.Join(append(append([]string{}, path[:len(path)-1]...), candidates[0]), ".")
+	return fmt.Sprintf("%s, did you mean %s defined in %s?", problem, p, sourceOf(p))
+}
+
+func (rule *Rule) check(val interface{}, secret bool) string {
+	got := func(s string) string {
+		if secret {
+			return rerrors.Redacted
+		}
+		return s
+	}
+	if !hasType(val, rule.Type) {
+		return fmt.Sprintf("must be of type %s, got %s", rule.Type, got(describe(val)))
+	}
+	if len(rule.Enum) != 0 {
+		ok := false
+		for _, allowed := range rule.Enum {
+			if fmt.Sprint(allowed) == fmt.Sprint(val) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			allowed := make([]string, len(rule.Enum))
+			for i, a := range rule.Enum {
+				allowed[i] = fmt.Sprint(a)
+			}
+			return fmt.Sprintf("must be one of %s, got %s", strings.Join(allowed, ", "), got(describe(val)))
+		}
+	}
+	if rule.Min != nil || rule.Max != nil {
+		n, ok := size(val, rule.Type)
+		if !ok {
+			return fmt.Sprintf("must be a number, a string or a list to have min or max, got %s", got(describe(val)))
+		}
+		if rule.Min != nil && n < *rule.Min {
+			return fmt.Sprintf("must be at least %v, got %s", *rule.Min, got(fmt.Sprint(n)))
+		}
+		if rule.Max != nil && n > *rule.Max {
+			return fmt.Sprintf("must be at most %v, got %s", *rule.Max, got(fmt.Sprint(n)))
+		}
+	}
+	if rule.pattern != nil {
+		s, ok := val.(string)
+		if !ok {
+			s = fmt.Sprint(val)
+		}
+		if !rule.pattern.MatchString(s) {
+			return fmt.Sprintf("must match %s, got %s", rule.Pattern, got(describe(val)))
+		}
+	}
+	return ""
+}
+
+func hasType(val interface{}, typ string) bool {
+	switch typ {
+	case TypeAny:
+		return true
+	case TypeString:
+		_, ok := val.(string)
+		return ok
+	case TypeInt:
+		switch v := val.(type) {
+		case int, int64, uint64:
+			return true
+		case string:
+			_, err := strconv.ParseInt(v, 10, 64)
+			return err == nil
+		}
+	case TypeFloat:
+		switch v := val.(type) {
+		case int, int64, uint64, float64:
+			return true
+		case string:
+			_, err := strconv.ParseFloat(v, 64)
+			return err == nil
+		}
+	case TypeBool:
+		switch v := val.(type) {
+		case bool:
+			return true
+		case string:
+			_, err := strconv.ParseBool(v)
+			return err == nil
+		}
+	case TypeList:
+		_, ok := val.([]interface{})
+		return ok
+	case TypeMap:
+		_, ok := val.(map[string]interface{})
+		return ok
+	}
+	return false
+}
+
+// size is a number itself, a length of a string or of a list.
+func size(val interface{}, typ string) (float64, bool) {
+	switch v := val.(type) {
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float64:
+		return v, true
+	case string:
+		if typ != TypeInt && typ != TypeFloat {
+			return float64(len(v)), true
+		}
+		if n, err := strconv.ParseFloat(v, 64); err == nil {
+			return n, true
+		}
+		return 0, false
+	case []interface{}:
+		return float64(len(v)), true
+	}
+	return 0, false
+}
+
+func describe(val interface{}) string {
+	switch v := val.(type) {
+	case nil:
+		return "null"
+	case string:
+		return strconv.Quote(v)
+	case []interface{}:
+		return "a list"
+	case map[string]interface{}:
+		return "a map"
+	}
+	return fmt.Sprint(val)
+}
+
+// distance is the Levenshtein distance between a and b.
+func distance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j] + 1
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+			if prev[j-1]+cost < cur[j] {
+				cur[j] = prev[j-1] + cost
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+type ErrInvalidVars struct {
+	// Problems of instances by their names
+	Problems map[string][]string
+}
+
+func (e *ErrInvalidVars) NiceError() string {
+	insts := make([]string, 0, len(e.Problems))
+	for inst := range e.Problems {
+		insts = append(insts, inst)
+	}
+	sort.Strings(insts)
+	b := strings.Builder{}
+	b.WriteString("Variables are not valid:")
+	for _, inst := range insts {
+		fmt.Fprintf(&b, "\n%s:", inst)
+		for _, p := range e.Problems[inst] {
+			fmt.Fprintf(&b, "\n\t%s", p)
+		}
+	}
+	return b.String()
+}
+
+func (e *ErrInvalidVars) Error() string {
+	return e.NiceError()
+}
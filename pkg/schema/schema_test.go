@@ -0,0 +1,38 @@
+package schema
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRuleCheckMinMax(t *testing.T) {
+	three := 3.0
+	for _, tc := range []struct {
+		typ  string
+		val  interface{}
+		want string
+	}{
+		{TypeString, "10", "must be at least 3, got 2"},
+		{TypeString, "abc", ""},
+		{TypeAny, "10", "must be at least 3, got 2"},
+		{TypeInt, "10", ""},
+		{TypeInt, "2", "must be at least 3, got 2"},
+		{TypeInt, 2, "must be at least 3, got 2"},
+		{TypeFloat, "2.5", "must be at least 3, got 2.5"},
+		{TypeList, []interface{}{1, 2, 3}, ""},
+		{TypeList, []interface{}{1}, "must be at least 3, got 1"},
+	} {
+		rule := &Rule{Type: tc.typ, Min: &three}
+		got := rule.check(tc.val, false)
+		if tc.want == "" && got != "" || !strings.HasPrefix(got, tc.want) {
+			t.Errorf("%s %#v: got %q, want %q", tc.typ, tc.val, got, tc.want)
+		}
+	}
+}
+
+func TestRuleCheckRedactsSecrets(t *testing.T) {
+	rule := &Rule{Type: TypeString, Enum: []interface{}{"a", "b"}}
+	if got := rule.check("s3cr3t", true); strings.Contains(got, "s3cr3t") {
+		t.Errorf("got %q, the secret is not redacted", got)
+	}
+}
@@ -0,0 +1,261 @@
+package secrets
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"golden/pkg/rerrors"
+	"golden/pkg/sh"
+	"hash"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Prefix starts every encrypted value. An encrypted value is
+// Prefix + base64(salt | nonce | AES-256-GCM ciphertext) with the key
+// derived from a passphrase by PBKDF2-HMAC-SHA256.
+//
+// A single value in a var file is encrypted with the !encrypted tag:
+//
+//	password: !encrypted $GOLDEN;1;...
+//
+// A whole file is encrypted if it consists of an encrypted value only.
+const Prefix = "$GOLDEN;1;"
+
+// Tag marks encrypted values in yaml.
+const Tag = "!encrypted"
+
+const (
+	saltSize   = 16
+	keySize    = 32
+	iterations = 100000
+)
+
+// KeyFileEnv and PassphraseEnv provide the passphrase when no key file is
+// set explicitly. DefaultKeyFile is used when neither is set and it exists,
+// otherwise the passphrase is asked on the terminal.
+const (
+	KeyFileEnv    = "GOLDEN_SECRETS_KEY_FILE"
+	PassphraseEnv = "GOLDEN_SECRETS_PASSPHRASE"
+)
+
+var DefaultKeyFile = filepath.Join("~", ".golden", "secrets.key")
+
+// ValueRegexp matches encrypted values within a text.
+var ValueRegexp = regexp.MustCompile(regexp.QuoteMeta(Prefix) + `[A-Za-z0-9+/=]+`)
+
+var wholeValueRegexp = regexp.MustCompile("^" + ValueRegexp.String() + "$")
+
+type ErrSecrets struct {
+	ctx string
+	raw error
+}
+
+func (e *ErrSecrets) NiceError() string {
+	return fmt.Sprintf("Failed to %s: %s", e.ctx, e.raw)
+}
+
+func (e *ErrSecrets) Error() string {
+	return e.NiceError()
+}
+
+var (
+	keyFile    string
+	passphrase string
+	keys       = map[string][]byte{}
+)
+
+// SetKeyFile sets a file with the passphrase overriding the environment.
+func SetKeyFile(filename string) {
+	keyFile = filename
+	passphrase = ""
+}
+
+// MustPassphrase returns the passphrase, reading it once from a key file,
+// the environment or the terminal.
+func MustPassphrase() string {
+	if passphrase != "" {
+		return passphrase
+	}
+	if p, ok := passphraseFromFileOrEnv(keyFile); ok {
+		passphrase = p
+		return passphrase
+	}
+	passphrase = sh.MustReadPassword("Secrets passphrase: ")
+	return passphrase
+}
+
+// MustNewPassphrase returns a passphrase to encrypt with. It is read from
+// keyFile, or the same way as MustPassphrase, except that a passphrase
+// typed on the terminal must be typed twice.
+func MustNewPassphrase(keyFile string) string {
+	if p, ok := passphraseFromFileOrEnv(keyFile); ok {
+		return p
+	}
+	return MustAskNewPassphrase()
+}
+
+// MustAskNewPassphrase asks a new passphrase on the terminal twice,
+// ignoring key files and the environment.
+func MustAskNewPassphrase() string {
+	p := sh.MustReadPassword("New secrets passphrase: ")
+	if p == "" {
+		panic(rerrors.NewErrStringf("secrets passphrase must not be empty"))
+	}
+	if sh.MustReadPassword("Repeat the passphrase: ") != p {
+		panic(rerrors.NewErrStringf("passphrases do not match"))
+	}
+	return p
+}
+
+func passphraseFromFileOrEnv(filename string) (string, bool) {
+	if filename == "" {
+		filename = os.Getenv(KeyFileEnv)
+	}
+	if filename == "" {
+		if p := os.Getenv(PassphraseEnv); p != "" {
+			return p, true
+		}
+		if home, err := os.UserHomeDir(); err == nil {
+			if def := filepath.Join(home, strings.TrimPrefix(DefaultKeyFile, "~")); fileExists(def) {
+				filename = def
+			}
+		}
+	}
+	if filename == "" {
+		return "", false
+	}
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		panic(rerrors.NewErrIo(filename, "reading secrets key file", err))
+	}
+	p := strings.TrimSpace(string(content))
+	if p == "" {
+		panic(rerrors.NewErrStringf("secrets key file %s is empty", filename))
+	}
+	return p, true
+}
+
+func fileExists(filename string) bool {
+	fi, err := os.Stat(filename)
+	return err == nil && !fi.IsDir()
+}
+
+// IsEncryptedFile reports whether a whole file is encrypted.
+func IsEncryptedFile(filename string) bool {
+	data, err := os.ReadFile(filename)
+	return err == nil && IsEncrypted(data)
+}
+
+// IsEncrypted reports whether data is a single encrypted value,
+// e. g. content of a whole encrypted file.
+func IsEncrypted(data []byte) bool {
+	return wholeValueRegexp.Match(bytes.TrimSpace(data))
+}
+
+// Encrypt encrypts plaintext with a passphrase.
+func Encrypt(plaintext []byte, passphrase string) string {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		panic(err)
+	}
+	gcm := newGCM(passphrase, salt)
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		panic(err)
+	}
+	out := append(append(salt, nonce...), gcm.Seal(nil, nonce, plaintext, nil)...)
+	return Prefix + base64.StdEncoding.EncodeToString(out)
+}
+
+// Decrypt decrypts a value produced by Encrypt.
+func Decrypt(value, passphrase string) ([]byte, error) {
+	value = strings.TrimSpace(value)
+	if !strings.HasPrefix(value, Prefix) {
+		return nil, fmt.Errorf("not an encrypted value, must start with %s", Prefix)
+	}
+	raw, err := base64.StdEncoding.DecodeString(value[len(Prefix):])
+	if err != nil {
+		return nil, fmt.Errorf("malformed encrypted value: %s", err)
+	}
+	if len(raw) < saltSize {
+		return nil, fmt.Errorf("malformed encrypted value: too short")
+	}
+	gcm := newGCM(passphrase, raw[:saltSize])
+	raw = raw[saltSize:]
+	if len(raw) < gcm.NonceSize() {
+		return nil, fmt.Errorf("malformed encrypted value: too short")
+	}
+	plaintext, err := gcm.Open(nil, raw[:gcm.NonceSize()], raw[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("wrong passphrase or corrupted value")
+	}
+	return plaintext, nil
+}
+
+// MustDecrypt decrypts a value with the passphrase of MustPassphrase
+// and registers it for redaction.
+func MustDecrypt(value, ctx string) string {
+	plaintext, err := Decrypt(value, MustPassphrase())
+	if err != nil {
+		panic(&ErrSecrets{"decrypt " + ctx, err})
+	}
+	rerrors.AddSecret(string(plaintext))
+	return string(plaintext)
+}
+
+func newGCM(passphrase string, salt []byte) cipher.AEAD {
+	cacheKey := passphrase + "\x00" + string(salt)
+	key, ok := keys[cacheKey]
+	if !ok {
+		key = pbkdf2(sha256.New, []byte(passphrase), salt, iterations, keySize)
+		keys[cacheKey] = key
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		panic(err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		panic(err)
+	}
+	return gcm
+}
+
+// pbkdf2 implements PBKDF2 of RFC 8018 with HMAC of h.
+func pbkdf2(h func() hash.Hash, password, salt []byte, iter, keyLen int) []byte {
+	prf := hmac.New(h, password)
+	hashLen := prf.Size()
+	numBlocks := (keyLen + hashLen - 1) / hashLen
+
+	var blockIndex [4]byte
+	dk := make([]byte, 0, numBlocks*hashLen)
+	u := make([]byte, hashLen)
+	for block := 1; block <= numBlocks; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(blockIndex[:], uint32(block))
+		prf.Write(blockIndex[:])
+		dk = prf.Sum(dk)
+		t := dk[len(dk)-hashLen:]
+		copy(u, t)
+
+		for n := 2; n <= iter; n++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for i := range u {
+				t[i] ^= u[i]
+			}
+		}
+	}
+	return dk[:keyLen]
+}
@@ -0,0 +1,74 @@
+package secrets
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// Test vectors of RFC 6070 for PBKDF2-HMAC-SHA1.
+func TestPbkdf2(t *testing.T) {
+	for _, tc := range []struct {
+		password, salt string
+		iter, keyLen   int
+		want           string
+	}{
+		{"password", "salt", 1, 20, "0c60c80f961f0e71f3a9b524af6012062fe037a6"},
+		{"password", "salt", 2, 20, "ea6c014dc72d6f8ccd1ed92ace1d41f0d8de8957"},
+		{"password", "salt", 4096, 20, "4b007901b765489abead49d926f721d065a429c1"},
+		{
+			"passwordPASSWORDpassword", "saltSALTsaltSALTsaltSALTsaltSALTsalt", 4096, 25,
+			"3d2eec4fe41c849b80c8d83662c0e44a8b291a964cf2f07038",
+		},
+		{"pass\x00word", "sa\x00lt", 4096, 16, "56fa6aa75548099dcc37d7f03425e0c3"},
+	} {
+		got := hex.EncodeToString(pbkdf2(sha1.New, []byte(tc.password), []byte(tc.salt), tc.iter, tc.keyLen))
+		if got != tc.want {
+			t.Errorf("pbkdf2(%q, %q, %d, %d) = %s, want %s", tc.password, tc.salt, tc.iter, tc.keyLen, got, tc.want)
+		}
+	}
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	for _, plaintext := range []string{"", "s3cr3t", "multi\nline\n", strings.Repeat("x", 10000)} {
+		encrypted := Encrypt([]byte(plaintext), "passphrase")
+		if !IsEncrypted([]byte(encrypted + "\n")) {
+			t.Errorf("Encrypt(%.10q) = %s, not recognized as encrypted", plaintext, encrypted)
+		}
+		if strings.Contains(encrypted, plaintext) && plaintext != "" {
+			t.Errorf("Encrypt(%.10q) contains the plaintext", plaintext)
+		}
+		decrypted, err := Decrypt(encrypted, "passphrase")
+		if err != nil {
+			t.Fatalf("Decrypt: %s", err)
+		}
+		if string(decrypted) != plaintext {
+			t.Errorf("Decrypt(Encrypt(%.10q)) = %.10q", plaintext, decrypted)
+		}
+	}
+}
+
+func TestEncryptUsesFreshSalt(t *testing.T) {
+	if Encrypt([]byte("a"), "passphrase") == Encrypt([]byte("a"), "passphrase") {
+		t.Error("encrypting the same value twice gives the same result")
+	}
+}
+
+func TestDecryptErrors(t *testing.T) {
+	encrypted := Encrypt([]byte("s3cr3t"), "passphrase")
+	for name, tc := range map[string]struct {
+		value, passphrase, want string
+	}{
+		"wrong passphrase": {encrypted, "other", "wrong passphrase"},
+		"corrupted":        {encrypted[:len(encrypted)-8] + "AAAAAAAA", "passphrase", "wrong passphrase"},
+		"no prefix":        {"s3cr3t", "passphrase", "not an encrypted value"},
+		"not base64":       {Prefix + "!!!", "passphrase", "malformed"},
+		"too short":        {Prefix + "AAAA", "passphrase", "too short"},
+	} {
+		_, err := Decrypt(tc.value, tc.passphrase)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: got %v, want an error containing %q", name, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package sh
+
+import (
+	"fmt"
+)
+
+const (
+	BecomeMethodSudo = "sudo"
+	BecomeMethodSu   = "su"
+)
+
+// Become runs commands as another user on top of an executor
+// which logs in to a host, e. g. an ssh session.
+type Become struct {
+	login    Executor
+	user     string
+	method   string
+	password string
+}
+
+// NewBecome wraps login. If password is not empty, sudo -S -v is given it
+// before every command, otherwise sudo must not ask for a password.
+// su is only supported without a password, i. e. when logged in as root.
+func NewBecome(login Executor, user, method, password string) *Become {
+	if method == "" {
+		method = BecomeMethodSudo
+	}
+	switch method {
+	case BecomeMethodSudo:
+	case BecomeMethodSu:
+		if password != "" {
+			panic(&ErrBecome{user, method, "a password can only be passed to sudo"})
+		}
+	default:
+		panic(&ErrBecome{user, method, "become_method must be either sudo or su"})
+	}
+	return &Become{login: login, user: user, method: method, password: password}
+}
+
+type ErrBecome struct {
+	user   string
+	method string
+	msg    string
+}
+
+func (e *ErrBecome) NiceError() string {
+	return fmt.Sprintf("Cannot become %s with %s: %s", e.user, e.method, e.msg)
+}
+
+func (b *Become) wrap(command string) string {
+	if b.method == BecomeMethodSu {
+		return fmt.Sprintf("su - %s -c %s", Quote(b.user), Quote(command))
+	}
+	return fmt.Sprintf("sudo -n -iu %s sh -c %s", Quote(b.user), Quote(command))
+}
+
+// authenticate reads the password from the first line of stdin and
+// validates sudo credentials with it, so that the command run with sudo -n
+// afterwards by the same shell gets the rest of stdin whether sudo has
+// cached the credentials or not.
+const authenticate = `IFS= read -r p && printf '%s\n' "$p" | sudo -S -p '' -v && unset p && `
+
+func (b *Become) MustDoSilentlyf(format string, args ...interface{}) {
+	b.MustDoSilentlyWithInputf("", format, args...)
+}
+
+func (b *Become) MustDoSilentlyWithInputf(input string, format string, args ...interface{}) {
+	command := b.wrap(fmt.Sprintf(format, args...))
+	if b.password == "" {
+		if input == "" {
+			b.login.MustDoSilentlyf("%s", command)
+			return
+		}
+		b.login.MustDoSilentlyWithInputf(input, "%s", command)
+		return
+	}
+	b.login.MustDoSilentlyWithInputf(b.password+"\n"+input, "%s", authenticate+command)
+}
+
+// MustDoSilentlyFromFilef lets the login user read the file,
+// so it does not have to be accessible by the user to become.
+func (b *Become) MustDoSilentlyFromFilef(file string, format string, args ...interface{}) {
+	command := b.wrap(fmt.Sprintf(format, args...))
+	if b.password == "" {
+		b.login.MustDoSilentlyFromFilef(file, "%s", command)
+		return
+	}
+	b.login.MustDoSilentlyWithInputf(b.password+"\n", "%s", authenticate+"cat "+Quote(file)+" | "+command)
+}
+
+func (b *Become) MustCp(src, dest string) {
+	panic(&ErrBecome{b.user, b.method, "copying is not supported, copy with the login executor"})
+}
@@ -2,5 +2,10 @@ package sh
 
 type Executor interface{
 	MustDoSilentlyf(format string, args... interface{})
+	// MustDoSilentlyWithInputf passes input to stdin of a command.
+	MustDoSilentlyWithInputf(input string, format string, args... interface{})
+	// MustDoSilentlyFromFilef passes a file to stdin of a command.
+	// The file is opened by the user the executor logs in as.
+	MustDoSilentlyFromFilef(file string, format string, args... interface{})
 	MustCp(src, dest string)
-}
\ No newline at end of file
+}
@@ -1,9 +1,13 @@
 package sh
 
 import (
+	"bufio"
+	"bytes"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"strings"
 )
 
 type ErrCmd struct {
@@ -56,6 +60,15 @@ func MustDoSilentlyf(format string, args ...interface{}) {
 	MustDoSilently(fmt.Sprintf(format, args...))
 }
 
+func MustDoSilentlyWithInput(command string, input string) {
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stdin = strings.NewReader(input)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		panic(newErrCmd(command, out, err))
+	}
+}
+
 func MustCp(src, dest string) {
 	MustDoSilentlyf("cp %s %s", src, dest)
 }
@@ -66,8 +79,68 @@ func (s shellT) MustDoSilentlyf(format string, args ...interface{}) {
 	MustDoSilentlyf(format, args...)
 }
 
+func (s shellT) MustDoSilentlyWithInputf(input string, format string, args ...interface{}) {
+	MustDoSilentlyWithInput(fmt.Sprintf(format, args...), input)
+}
+
+func (s shellT) MustDoSilentlyFromFilef(file string, format string, args ...interface{}) {
+	MustDoSilentlyf("%s < %s", fmt.Sprintf(format, args...), file)
+}
+
 func (s shellT) MustCp(src, dest string) {
 	MustCp(src, dest)
 }
 
-var Shell shellT
\ No newline at end of file
+var Shell shellT
+
+// MustGetOutput runs an executable directly, without a shell, and returns its stdout.
+func MustGetOutput(name string, args ...string) []byte {
+	return MustGetOutputIn("", name, args...)
+}
+
+// MustGetOutputIn is MustGetOutput running a command within dir.
+func MustGetOutputIn(dir, name string, args ...string) []byte {
+	cmd := exec.Command(name, args...)
+	cmd.Dir = dir
+	stderr := bytes.Buffer{}
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		panic(newErrCmd(strings.Join(append([]string{name}, args...), " "), stderr.Bytes(), err))
+	}
+	return out
+}
+
+// Quote quotes a string for sh so it is passed as a single word.
+func Quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// MustReadPassword prompts for a password on the terminal without echoing it.
+func MustReadPassword(prompt string) string {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		panic(err)
+	}
+	defer tty.Close()
+
+	stty := func(arg string) {
+		cmd := exec.Command("stty", arg)
+		cmd.Stdin = tty
+		if out, err := cmd.CombinedOutput(); err != nil {
+			panic(newErrCmd("stty "+arg, out, err))
+		}
+	}
+	fmt.Fprint(tty, prompt)
+	stty("-echo")
+	defer func() {
+		stty("echo")
+		fmt.Fprintln(tty)
+	}()
+
+	line, err := bufio.NewReader(tty).ReadString('\n')
+	if err != nil && err != io.EOF {
+		panic(err)
+	}
+	return strings.TrimRight(line, "\r\n")
+}
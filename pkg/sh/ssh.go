@@ -1,26 +1,52 @@
 package sh
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 type SshSession struct {
 	controlPath string
 	connStr string
+	args string
 }
 
-func NewSshSession(controlPath string, connStr string) *SshSession {
-	ssh := &SshSession{controlPath: controlPath, connStr: connStr}
-	MustDof("ssh -M -o \"ControlPath=%s\" -o \"ControlPersist=yes\" %s true", controlPath, connStr)
+// NewSshSession opens a master connection. args are extra options passed
+// to every ssh and scp invocation, e. g. "-o", "Port=2222".
+func NewSshSession(controlPath string, connStr string, args []string) *SshSession {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = Quote(arg)
+	}
+	ssh := &SshSession{controlPath: controlPath, connStr: connStr, args: strings.Join(quoted, " ")}
+	MustDof("ssh -M -o \"ControlPath=%s\" -o \"ControlPersist=yes\" %s %s true", controlPath, ssh.args, connStr)
 	return ssh
 }
 
 func (ssh *SshSession) Close() {
-	MustDoSilentlyf("ssh -o \"ControlPath=%s\" -O exit %s", ssh.controlPath, ssh.connStr)
+	MustDoSilentlyf("ssh -o \"ControlPath=%s\" %s -O exit %s", ssh.controlPath, ssh.args, ssh.connStr)
 }
 
 func (ssh *SshSession) MustCp(src, dest string) {
-	MustDoSilentlyf("scp -o \"ControlPath=%s\" %s %s:%s", ssh.controlPath, src, ssh.connStr, dest)
+	MustDoSilentlyf("scp -o \"ControlPath=%s\" %s %s %s:%s", ssh.controlPath, ssh.args, src, ssh.connStr, dest)
+}
+
+// remoteCommand quotes a command so it is parsed by the remote shell only.
+func (ssh *SshSession) remoteCommand(format string, args... interface{}) string {
+	return fmt.Sprintf(
+		"ssh -S %s %s %s %s",
+		ssh.controlPath, ssh.args, ssh.connStr, Quote(fmt.Sprintf(format, args...)),
+	)
 }
 
 func (ssh *SshSession) MustDoSilentlyf(format string, args... interface{}) {
-	MustDoSilentlyf("ssh -S %s %s %s", ssh.controlPath, ssh.connStr, fmt.Sprintf(format, args...))
-}
\ No newline at end of file
+	MustDoSilently(ssh.remoteCommand(format, args...))
+}
+
+func (ssh *SshSession) MustDoSilentlyWithInputf(input string, format string, args... interface{}) {
+	MustDoSilentlyWithInput(ssh.remoteCommand(format, args...), input)
+}
+
+func (ssh *SshSession) MustDoSilentlyFromFilef(file string, format string, args... interface{}) {
+	MustDoSilently(ssh.remoteCommand("%s < %s", fmt.Sprintf(format, args...), file))
+}
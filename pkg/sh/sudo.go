@@ -27,6 +27,15 @@ func (s Sudo) MustDoSilentlyf(format string, args ...interface{}) {
 	MustDoSilentlyf("sudo -iu %s %s", s.user, fmt.Sprintf(format, args...))
 }
 
+func (s Sudo) MustDoSilentlyWithInputf(input string, format string, args ...interface{}) {
+	MustDoSilentlyWithInput(fmt.Sprintf("sudo -iu %s sh -c %s", s.user, Quote(fmt.Sprintf(format, args...))), input)
+}
+
+func (s Sudo) MustDoSilentlyFromFilef(file string, format string, args ...interface{}) {
+	command := fmt.Sprintf("%s < %s", fmt.Sprintf(format, args...), file)
+	MustDoSilentlyf("sudo -iu %s sh -c %s", s.user, Quote(command))
+}
+
 func (s Sudo) MustCp(src, dest string) {
 	s.MustDoSilentlyf("cp %s %s", src, filepath.Join(s.userHomeDir, dest))
 }
\ No newline at end of file
@@ -15,3 +15,7 @@ func (e *ResolutionError) Error() string {
 		e.Sources[1],
 	)
 }
+
+func (e *ResolutionError) NiceError() string {
+	return e.Error()
+}
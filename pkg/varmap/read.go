@@ -1,22 +1,82 @@
 package varmap
 
 import (
+	"golden/pkg/rerrors"
 	"golden/pkg/ryaml"
+	"golden/pkg/secrets"
+	"strings"
 )
 
+// Read reads variables of fileBaseNameOrDir with any of ryaml.Extensions
+// or of every such file within fileBaseNameOrDir. Files of a directory must not define the same variables.
+// It reports errors of all files at once.
 func Read(fileBaseNameOrDir string) VarMap {
 	filenamesList := []string{}
-	maps := ryaml.ReadYamlRecursive(fileBaseNameOrDir, func(filename string) interface{} {
+	errs := &rerrors.Collector{}
+	maps := ryaml.CollectYamlRecursive(fileBaseNameOrDir, func(filename string) interface{} {
 		filenamesList = append(filenamesList, filename)
 		m := New()
 		return &m
-	})
+	}, errs)
 	merged := New()
 	for i, m := range maps {
 		vm := *m.(*VarMap)
 		vm.SetSource(filenamesList[i])
-		merged = Merge(merged, vm, ConflictResolutionError)
+		if secrets.IsEncryptedFile(filenamesList[i]) {
+			vm.SetSecret()
+		}
+		errs.Catch(func() { merged = Merge(merged, vm, ConflictResolutionError) })
 	}
+	errs.PanicIfAny()
 	merged.SetPaths()
 	return merged
 }
+
+// CliSource is the source of variables set on the command line.
+const CliSource = "cli"
+
+// ReadCliFile reads variables of a file given on the command line whatever
+// its extension. Its variables have the cli source and no position, like
+// those of ParseAssignments.
+func ReadCliFile(filename string) VarMap {
+	m := New()
+	ryaml.ReadYamlFile(filename, &m)
+	m.SetSource(CliSource)
+	m.clearPositions()
+	if secrets.IsEncryptedFile(filename) {
+		m.SetSecret()
+	}
+	m.SetPaths()
+	return m
+}
+
+// ParseAssignments parses "key.path=value" assignments into a VarMap.
+// Values are strings, later assignments override earlier ones.
+func ParseAssignments(assignments []string) VarMap {
+	out := New()
+	for _, a := range assignments {
+		key, value, found := strings.Cut(a, "=")
+		if !found || key == "" {
+			panic(rerrors.NewErrStringf("invalid variable %q: must look like key.path=value", a))
+		}
+		elements := strings.Split(key, ".")
+		m := New()
+		var top VarMap = m
+		for i, el := range elements {
+			if el == "" {
+				panic(rerrors.NewErrStringf("invalid variable %q: empty key in path %s", a, key))
+			}
+			if i == len(elements)-1 {
+				m[el] = &Var{Value: value}
+				continue
+			}
+			sub := New()
+			m[el] = &Var{Value: sub}
+			m = sub
+		}
+		top.SetSource(CliSource)
+		out = Merge(out, top, ConflictResolutionOverride)
+	}
+	out.SetPaths()
+	return out
+}
@@ -0,0 +1,46 @@
+package varmap
+
+import (
+	"golden/pkg/rerrors/rerrorstest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadRejectsDuplicateKeys(t *testing.T) {
+	for name, content := range map[string]string{
+		"top level": "port: 1\nport: 2\n",
+		"nested":    "server:\n  port: 1\n  port: 2\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "vars.yml")
+			if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			err := rerrorstest.Message(func() { Read(file) })
+			if !strings.Contains(err, `mapping key "port" already defined at line`) {
+				t.Errorf("got %q, want a duplicate key error", err)
+			}
+		})
+	}
+}
+
+func TestReadCliFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "extra.vars")
+	if err := os.WriteFile(file, []byte("release:\n  version: 1.2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m := ReadCliFile(file)
+	release, ok := m["release"].Value.(VarMap)
+	if !ok {
+		t.Fatalf("got %v, want release to be a map", m)
+	}
+	version := release["version"]
+	if version == nil || version.Value != 1.2 {
+		t.Fatalf("got %v, want release.version of 1.2", release)
+	}
+	if version.Source != CliSource || m["release"].Source != CliSource {
+		t.Errorf("got sources %q and %q, want %q", m["release"].Source, version.Source, CliSource)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"golden/pkg/rerrors"
 	"golden/pkg/rtemplate"
+	"golden/pkg/ryaml"
+	"golden/pkg/secrets"
 	"path/filepath"
 	"strings"
 	"text/template"
@@ -17,6 +19,17 @@ type Var struct {
 	Value  interface{}
 	Path   *Path
 	Source string
+	// Line and Col of the key defining the variable within Source, 0 if unknown
+	Line int
+	Col  int
+	// Secret is set for values decrypted from !encrypted values
+	// or from encrypted files
+	Secret bool
+}
+
+// Location is Source with Line and Col, e. g. "host_vars/h1.yml:3:5".
+func (v *Var) Location() string {
+	return rerrors.Pos{File: v.Source, Line: v.Line, Col: v.Col}.String()
 }
 
 func New() VarMap {
@@ -42,12 +55,13 @@ func merge(commonPath *Path, lower, higher VarMap, cr ConflictResolution) VarMap
 				if !alsoVarMap {
 					panic(rerrors.NewErrStringf(
 						"Variables types mismatch:\n%s: [%s] - a map\n%s [%s] - not a map",
-						lowerV.Path.String(), lowerV.Source,
-						higherV.Path.String(), higherV.Source))
+						lowerV.Path.String(), lowerV.Location(),
+						higherV.Path.String(), higherV.Location()))
 				}
 				thisPath := commonPath.CopyJoin(higherK)
-				subMerged := merge(commonPath.Join(higherK), lowerSubMap, higherSubMap, cr)
-				merged[higherK] = &Var{subMerged, thisPath, higherV.Source}
+				// lower maps may be shared between instances, so they are never merged into in place
+				subMerged := merge(thisPath, lowerSubMap.shallowCopy(), higherSubMap, cr)
+				merged[higherK] = &Var{Value: subMerged, Path: thisPath, Source: higherV.Source, Line: higherV.Line, Col: higherV.Col}
 				continue
 			}
 
@@ -56,10 +70,10 @@ func merge(commonPath *Path, lower, higher VarMap, cr ConflictResolution) VarMap
 				merged[higherK] = higherV
 				continue
 			case ConflictResolutionError:
-				conflictPath := commonPath.Join(higherK)
+				conflictPath := commonPath.CopyJoin(higherK)
 				panic(&ResolutionError{
 					Path:    *conflictPath,
-					Sources: [2]string{lowerV.Source, higherV.Source},
+					Sources: [2]string{lowerV.Location(), higherV.Location()},
 				})
 			}
 			panic("unreachable")
@@ -70,6 +84,14 @@ func merge(commonPath *Path, lower, higher VarMap, cr ConflictResolution) VarMap
 	return merged
 }
 
+func (m VarMap) shallowCopy() VarMap {
+	c := make(VarMap, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
 func Merge(lower, higher VarMap, cr ConflictResolution) VarMap {
 	return merge(NewPath(), lower, higher, cr)
 }
@@ -84,6 +106,19 @@ func (v *Var) UnmarshalYAML(node *yaml.Node) error {
 		v.Value = m
 		return nil
 	}
+	if node.Tag == secrets.Tag {
+		if node.Kind != yaml.ScalarNode {
+			return ryaml.NodeError(node, "%s must be followed by an encrypted string", secrets.Tag)
+		}
+		plaintext, err := secrets.Decrypt(node.Value, secrets.MustPassphrase())
+		if err != nil {
+			return ryaml.NodeError(node, "%s", err)
+		}
+		rerrors.AddSecret(string(plaintext))
+		v.Value = string(plaintext)
+		v.Secret = true
+		return nil
+	}
 	var anything interface{}
 	err := node.Decode(&anything)
 	if err != nil {
@@ -98,38 +133,29 @@ func (v *Var) UnmarshalYAML(node *yaml.Node) error {
 }
 
 func (m *VarMap) UnmarshalYAML(node *yaml.Node) error {
-	if node.Kind != yaml.MappingNode {
-		return fmt.Errorf("expected a map")
+	if *m == nil {
+		*m = New()
 	}
-	for i := 0; i < len(node.Content); i += 2 {
-		key := node.Content[i].Value
-		var value Var
-		valueNode := node.Content[i+1]
+	return ryaml.DecodeMapping(node, func(key, valueNode *yaml.Node) error {
+		value := Var{Line: key.Line, Col: key.Column}
 		err := value.UnmarshalYAML(valueNode)
-		if err != nil {
-			return err
-		}
-		(*m)[key] = &value
-	}
-	return nil
+		(*m)[key.Value] = &value
+		return err
+	})
 }
 
 func (m *VarMap) CustomUnmarshallYAML(data []byte) error {
-	type Doc struct {
-		Root VarMap `yaml:",inline"`
-	}
-	d := Doc{New()}
-	err := yaml.Unmarshal(data, &d)
+	doc := yaml.Node{}
+	err := yaml.Unmarshal(data, &doc)
 	if err != nil {
 		return err
 	}
-	*m = d.Root
-	for k, v := range *m {
-		if v == nil {
-			(*m)[k] = &Var{Value: ""}
-		}
+	*m = New()
+	// an empty file has no content, a file of comments only has null
+	if len(doc.Content) == 0 || doc.Content[0].Tag == "!!null" {
+		return nil
 	}
-	return nil
+	return m.UnmarshalYAML(doc.Content[0])
 }
 
 func (m VarMap) SetSource(filename string) {
@@ -141,6 +167,29 @@ func (m VarMap) SetSource(filename string) {
 	}
 }
 
+// clearPositions drops lines and columns, e. g. of variables which are
+// not located by their source.
+func (m VarMap) clearPositions() {
+	for _, v := range m {
+		v.Line, v.Col = 0, 0
+		if vm, ok := v.Value.(VarMap); ok {
+			vm.clearPositions()
+		}
+	}
+}
+
+// SetSecret marks all variables as secret and registers them for redaction.
+func (m VarMap) SetSecret() {
+	for _, v := range m {
+		if vm, ok := v.Value.(VarMap); ok {
+			vm.SetSecret()
+			continue
+		}
+		v.Secret = true
+		rerrors.AddSecret(fmt.Sprint(v.Value))
+	}
+}
+
 func (m VarMap) setPaths(commonPath *Path) {
 	for k, v := range m {
 		v.Path = commonPath.CopyJoin(k)
@@ -178,24 +227,24 @@ func setRegularMapValue(topMap map[string]interface{}, path *Path, val interface
 	}
 }
 
-func substituteTemplatedVars(templatedVars map[*Var]struct{}, topMap map[string]interface{}) {
+func substituteTemplatedVars(templatedVars map[*Var]struct{}, topMap map[string]interface{}, funcs template.FuncMap) {
 	for tv, _ := range templatedVars {
 		v := tv.Value.(string)
 
-		tmpl := template.Must(rtemplate.New("vartemplate").Option("missingkey=error").Parse(v))
+		tmpl := template.Must(rtemplate.New("vartemplate").Funcs(funcs).Option("missingkey=error").Parse(v))
 		buf := strings.Builder{}
 		err := tmpl.Execute(&buf, topMap)
 		if err != nil {
 			if strings.Contains(err.Error(), "no entry for key") {
 				continue
 			}
-			panic(rtemplate.NewErrExec(tv.Source, "resolving variable: "+tv.Path.String(), err))
+			panic(rtemplate.NewErrExec(tv.Location(), "resolving variable: "+tv.Path.String(), err))
 		}
 		newVal := buf.String()
 		setRegularMapValue(topMap, tv.Path, newVal)
 		newTmpl, err := rtemplate.New("vartemplate").Parse(newVal)
 		if err != nil {
-			panic(rtemplate.NewErrParse(fmt.Sprintf("%s: %s", tv.Source, tv.Path.String()), err))
+			panic(rtemplate.NewErrParse(fmt.Sprintf("%s: %s", tv.Location(), tv.Path.String()), err))
 		}
 		if !rtemplate.IsTemplate(newTmpl) {
 			delete(templatedVars, tv)
@@ -214,7 +263,7 @@ func (topMap VarMap) getAllTemplatedVarsWithTheirMaps() map[*Var]struct{} {
 			if str, ok := v.Value.(string); ok {
 				tmpl, err := rtemplate.New("vartemplate").Parse(str)
 				if err != nil {
-					panic(rtemplate.NewErrParse(fmt.Sprintf("%s: %s", v.Source, v.Path.String()), err))
+					panic(rtemplate.NewErrParse(fmt.Sprintf("%s: %s", v.Location(), v.Path.String()), err))
 				}
 				if rtemplate.IsTemplate(tmpl) {
 					out[v] = struct{}{}
@@ -242,6 +291,7 @@ func (e *ErrUnresolvedVariables) NiceError() string {
 		if err != nil {
 			panic(err)
 		}
+		absPath = rerrors.Pos{File: absPath, Line: tv.Line, Col: tv.Col}.String()
 		buf.WriteString(fmt.Sprintf("\n\t%s: defined in file - %s", tv.Path.String(), absPath))
 	}
 	return buf.String()
@@ -252,11 +302,17 @@ func (e *ErrUnresolvedVariables) Error() string {
 }
 
 func (m VarMap) SubstituteTemplatedVars() (resolvedVars map[string]interface{}, unresolvedVariables *ErrUnresolvedVariables) {
+	return m.SubstituteTemplatedVarsWith(nil)
+}
+
+// SubstituteTemplatedVarsWith is SubstituteTemplatedVars with additional
+// template functions available to templated variables.
+func (m VarMap) SubstituteTemplatedVarsWith(funcs template.FuncMap) (resolvedVars map[string]interface{}, unresolvedVariables *ErrUnresolvedVariables) {
 	regmap := m.toRegularMap()
 	templatedVars := m.getAllTemplatedVarsWithTheirMaps()
 	templatedVarsCount := len(templatedVars)
 	for templatedVarsCount != 0 {
-		substituteTemplatedVars(templatedVars, regmap)
+		substituteTemplatedVars(templatedVars, regmap, funcs)
 		if len(templatedVars) == templatedVarsCount {
 			substError := &ErrUnresolvedVariables{templatedVars}
 			return FilterOutUnresolvedVars(regmap, substError), substError